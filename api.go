@@ -16,6 +16,18 @@
 //   if err := <-errchan; err != nil {
 //     handle error
 //   }
+//
+// If you may stop reading before the end of the results, use the iterator
+// variants (e.g. Stream.RawValuesIter) instead. These need not be consumed,
+// but must be closed:
+//   it := some.MethodIter()
+//   defer it.Close()
+//   for it.Next() {
+//     do stuff with it.Value()
+//   }
+//   if err := it.Err(); err != nil {
+//     handle error
+//   }
 package btrdb
 
 import (
@@ -444,57 +456,61 @@ func (s *Stream) InsertF(ctx context.Context, length int, time func(int) int64,
 }
 
 //RawValues reads raw values from BTrDB. The returned RawPoint channel must be fully consumed.
+//If the endpoint serving the query fails part way through, the read is transparently resumed
+//on the new endpoint from the point after the last one delivered, at the same version, so the
+//values delivered are free of gaps and duplicates.
+//If the query result cache is enabled and version is not LatestVersion, the results
+//may be served from the cache.
 func (s *Stream) RawValues(ctx context.Context, start int64, end int64, version uint64) (chan RawPoint, chan uint64, chan error) {
-	var ep *Endpoint
-	var err error
-	for s.b.TestEpError(ep, err) {
-		ep, err = s.b.ReadEndpointFor(ctx, s.uuid)
+	if c := s.b.getCache(); c != nil && version != LatestVersion {
+		return c.rawValues(newCacheKey(s, cacheKindRaw, start, end, 0, 0, version), func() (chan RawPoint, chan uint64, chan error) {
+			return s.rawValues(ctx, start, end, version)
+		})
+	}
+	return s.rawValues(ctx, start, end, version)
+}
+
+func (s *Stream) rawValues(ctx context.Context, start int64, end int64, version uint64) (chan RawPoint, chan uint64, chan error) {
+	rvc := make(chan RawPoint, 100)
+	rvv := make(chan uint64, 1)
+	rve := make(chan error, 1)
+	go func() {
+		err := s.readIter(ctx, start, end, version, rvv, func(ep *Endpoint, start int64, version uint64) (resultIter, error) {
+			return ep.RawValuesIter(ctx, s.uuid, start, end, version)
+		}, func(it resultIter) int64 {
+			p := it.(*RawPointIterator).Value()
+			rvc <- p
+			return p.Time + 1
+		})
+		close(rvc)
+		close(rvv)
 		if err != nil {
-			continue
+			rve <- err
 		}
-		rvchan, rvvchan, errchan := ep.RawValues(ctx, s.uuid, start, end, version)
-		return rvchan, rvvchan, s.b.SnoopEpErr(ep, errchan)
-	}
-	if err == nil {
-		panic("Please report this")
-	}
-	rv := make(chan RawPoint)
-	close(rv)
-	rvv := make(chan uint64)
-	close(rvv)
-	errc := make(chan error, 1)
-	errc <- err
-	close(errc)
-	return rv, rvv, errc
+		close(rve)
+	}()
+	return rvc, rvv, rve
 }
 
 //AlignedWindows reads power-of-two aligned windows from BTrDB. It is faster than Windows(). Each returned window will be 2^pointwidth nanoseconds
 //long, starting at start. Note that start is inclusive, but end is exclusive. That is, results will be returned for all windows that start
 //in the interval [start, end). If end < start+2^pointwidth you will not get any results. If start and end are not powers of two, the bottom
 //pointwidth bits will be cleared. Each window will contain statistical summaries of the window. Statistical points with count == 0 will be
-//omitted.
+//omitted. Like RawValues, the read is transparently resumed if the endpoint fails part way through, and
+//may be served from the query result cache.
 func (s *Stream) AlignedWindows(ctx context.Context, start int64, end int64, pointwidth uint8, version uint64) (chan StatPoint, chan uint64, chan error) {
-	var ep *Endpoint
-	var err error
-	for s.b.TestEpError(ep, err) {
-		ep, err = s.b.ReadEndpointFor(ctx, s.uuid)
-		if err != nil {
-			continue
-		}
-		rvchan, rvvchan, errchan := ep.AlignedWindows(ctx, s.uuid, start, end, pointwidth, version)
-		return rvchan, rvvchan, s.b.SnoopEpErr(ep, errchan)
+	if c := s.b.getCache(); c != nil && version != LatestVersion {
+		return c.statPoints(newCacheKey(s, cacheKindAligned, start, end, uint64(pointwidth), 0, version), func() (chan StatPoint, chan uint64, chan error) {
+			return s.alignedWindows(ctx, start, end, pointwidth, version)
+		})
 	}
-	if err == nil {
-		panic("Please report this")
-	}
-	rv := make(chan StatPoint)
-	close(rv)
-	rvv := make(chan uint64)
-	close(rvv)
-	errc := make(chan error, 1)
-	errc <- err
-	close(errc)
-	return rv, rvv, errc
+	return s.alignedWindows(ctx, start, end, pointwidth, version)
+}
+
+func (s *Stream) alignedWindows(ctx context.Context, start int64, end int64, pointwidth uint8, version uint64) (chan StatPoint, chan uint64, chan error) {
+	return s.resumableWindows(ctx, start, end, 1<<pointwidth, version, func(ep *Endpoint, start int64, version uint64) (*StatPointIterator, error) {
+		return ep.AlignedWindowsIter(ctx, s.uuid, start, end, pointwidth, version)
+	})
 }
 
 //Windows returns arbitrary precision windows from BTrDB. It is slower than AlignedWindows, but still significantly faster than RawValues. Each returned
@@ -504,29 +520,118 @@ func (s *Stream) AlignedWindows(ctx context.Context, start int64, end int64, poi
 //parameter is an optimization that can be used to speed up queries on fast queries. Each window will be accurate to 2^depth nanoseconds. If depth is zero,
 //the results are accurate to the nanosecond. On a dense stream for large windows, this accuracy may not be required. For example for a window of a day, +- one
 //second may be appropriate, so a depth of 30 can be specified. This is much faster to execute on the database side. The StatPoint channel MUST be fully
-//consumed.
+//consumed. Like RawValues, the read is transparently resumed if the endpoint fails part way through, and
+//may be served from the query result cache.
 func (s *Stream) Windows(ctx context.Context, start int64, end int64, width uint64, depth uint8, version uint64) (chan StatPoint, chan uint64, chan error) {
+	if c := s.b.getCache(); c != nil && version != LatestVersion {
+		return c.statPoints(newCacheKey(s, cacheKindWindows, start, end, width, depth, version), func() (chan StatPoint, chan uint64, chan error) {
+			return s.windows(ctx, start, end, width, depth, version)
+		})
+	}
+	return s.windows(ctx, start, end, width, depth, version)
+}
+
+func (s *Stream) windows(ctx context.Context, start int64, end int64, width uint64, depth uint8, version uint64) (chan StatPoint, chan uint64, chan error) {
+	return s.resumableWindows(ctx, start, end, int64(width), version, func(ep *Endpoint, start int64, version uint64) (*StatPointIterator, error) {
+		return ep.WindowsIter(ctx, s.uuid, start, end, width, depth, version)
+	})
+}
+
+//resumableWindows drives a window query opened by the given function, reopening it
+//after the last delivered window (which is step nanoseconds wide) if the endpoint fails
+func (s *Stream) resumableWindows(ctx context.Context, start int64, end int64, step int64, version uint64, open func(ep *Endpoint, start int64, version uint64) (*StatPointIterator, error)) (chan StatPoint, chan uint64, chan error) {
+	rvc := make(chan StatPoint, 100)
+	rvv := make(chan uint64, 1)
+	rve := make(chan error, 1)
+	go func() {
+		err := s.readIter(ctx, start, end, version, rvv, func(ep *Endpoint, start int64, version uint64) (resultIter, error) {
+			return open(ep, start, version)
+		}, func(it resultIter) int64 {
+			p := it.(*StatPointIterator).Value()
+			rvc <- p
+			return p.Time + step
+		})
+		close(rvc)
+		close(rvv)
+		if err != nil {
+			rve <- err
+		}
+		close(rve)
+	}()
+	return rvc, rvv, rve
+}
+
+//resultIter is the part of the typed iterators used by readIter
+type resultIter interface {
+	Next() bool
+	Version() uint64
+	versionKnown() bool
+	Err() error
+	Close()
+}
+
+//readIter performs a read with resumeRead, opening an iterator on each endpoint
+//with open and passing each result to deliver, which returns the time to resume
+//from. The version is written to rvv before the first result is delivered, or
+//once it is known if there are no results.
+func (s *Stream) readIter(ctx context.Context, start int64, end int64, version uint64, rvv chan uint64,
+	open func(ep *Endpoint, start int64, version uint64) (resultIter, error), deliver func(it resultIter) int64) error {
+	wroteVer := false
+	_, err := s.resumeRead(ctx, start, end, version, func(ep *Endpoint, start int64, version uint64) (int64, uint64, error) {
+		it, err := open(ep, start, version)
+		if err != nil {
+			return start, 0, err
+		}
+		defer it.Close()
+		for it.Next() {
+			if !wroteVer {
+				rvv <- it.Version()
+				wroteVer = true
+			}
+			start = deliver(it)
+		}
+		var ver uint64
+		if it.versionKnown() {
+			ver = it.Version()
+			if !wroteVer {
+				rvv <- ver
+				wroteVer = true
+			}
+		}
+		return start, ver, it.Err()
+	})
+	return err
+}
+
+//resumeRead performs a read of [start, end) with read, which is given the
+//endpoint serving the stream, the time to read from and the version to read at.
+//It returns the time to resume from (after the last result it delivered), the
+//version it read at if known, and any error. If the endpoint fails, read is
+//called again on the new endpoint from where it left off, at the same version,
+//so the results are free of gaps and duplicates. The version used is returned.
+func (s *Stream) resumeRead(ctx context.Context, start int64, end int64, version uint64, read func(ep *Endpoint, start int64, version uint64) (int64, uint64, error)) (uint64, error) {
 	var ep *Endpoint
 	var err error
+	called := false
 	for s.b.TestEpError(ep, err) {
+		if called && start >= end {
+			//The endpoint failed after the last result was delivered, so there
+			//is nothing left to read, and the server would reject the empty range
+			return version, nil
+		}
 		ep, err = s.b.ReadEndpointFor(ctx, s.uuid)
 		if err != nil {
 			continue
 		}
-		rvchan, rvvchan, errchan := ep.Windows(ctx, s.uuid, start, end, width, depth, version)
-		return rvchan, rvvchan, s.b.SnoopEpErr(ep, errchan)
-	}
-	if err == nil {
-		panic("Please report this")
+		var ver uint64
+		start, ver, err = read(ep, start, version)
+		called = true
+		if ver != 0 {
+			//Pin the version so that a resumed read sees the same data
+			version = ver
+		}
 	}
-	rv := make(chan StatPoint)
-	close(rv)
-	rvv := make(chan uint64)
-	close(rvv)
-	errc := make(chan error, 1)
-	errc <- err
-	close(errc)
-	return rv, rvv, errc
+	return version, err
 }
 
 //DeleteRange will delete all points between start (inclusive) and end (exclusive). Note that BTrDB has persistent
@@ -544,6 +649,59 @@ func (s *Stream) DeleteRange(ctx context.Context, start int64, end int64) (ver u
 	return
 }
 
+//ReplaceRange will delete all points between start (inclusive) and end (exclusive) and then
+//insert the given points, which must all lie within [start, end). If the insert fails, the
+//points that existed before the delete are reinserted from the pre-delete version of the stream so
+//that the range is not left empty. Note that readers may still observe the intermediate version
+//between the delete and the insert, this is only atomic from the point of view of the caller.
+//The pre-delete version is read immediately before the delete, so a concurrent write to the
+//range that lands between the two is not restored if the insert fails.
+//returns the version of the stream after the replacement and any error
+func (s *Stream) ReplaceRange(ctx context.Context, start int64, end int64, vals []RawPoint) (uint64, error) {
+	if end <= start {
+		return 0, ErrorWrongArgs
+	}
+	for _, v := range vals {
+		if v.Time < start || v.Time >= end {
+			return 0, ErrorWrongArgs
+		}
+	}
+	prever, err := s.Version(ctx)
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.DeleteRange(ctx, start, end)
+	if err != nil {
+		return 0, err
+	}
+	err = s.Insert(ctx, vals)
+	if err != nil {
+		rerr := s.restoreRange(ctx, start, end, prever)
+		if rerr != nil {
+			lg.Errorf("could not restore range [%d, %d) of %s from version %d: %v", start, end, s.uuid.String(), prever, rerr)
+		}
+		return 0, err
+	}
+	return s.Version(ctx)
+}
+
+//restoreRange reinserts the raw values in [start, end) as they were at the given version. The
+//range is deleted first, as a failed Insert may have committed some of its batches.
+func (s *Stream) restoreRange(ctx context.Context, start int64, end int64, version uint64) error {
+	old := []RawPoint{}
+	rvc, _, errc := s.RawValues(ctx, start, end, version)
+	for p := range rvc {
+		old = append(old, p)
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+	if _, err := s.DeleteRange(ctx, start, end); err != nil {
+		return err
+	}
+	return s.Insert(ctx, old)
+}
+
 //Nearest will return the nearest point to the given time. If backward is false, the returned point
 //will be >= time. If backward is true, the returned point will be <time. The version of the
 //stream used to satisfy the query is returned.
@@ -0,0 +1,89 @@
+package btrdb
+
+import (
+	"context"
+	"sync"
+)
+
+//The maximum number of concurrent calls per endpoint in bulk operations such
+//as LatestValues
+const endpointParallelism = 8
+
+//NearestResult is the result of a Nearest query on one of several streams
+type NearestResult struct {
+	Stream  *Stream
+	Point   RawPoint
+	Version uint64
+	//The error from the query, e.g a 401 code if there is no such point
+	Err error
+}
+
+//LatestValues returns the latest point before the given time for each of the
+//given streams, as Stream.Latest would. The results are in the same order as
+//the streams. The streams are grouped by the endpoint that serves them and the
+//queries to each endpoint are issued concurrently, so this is much faster than
+//calling Latest on each stream in turn. Errors are reported per stream.
+func (b *BTrDB) LatestValues(ctx context.Context, streams []*Stream, before int64) []NearestResult {
+	return b.nearestValues(ctx, streams, before, true)
+}
+
+//EarliestValues returns the earliest point at or after the given time for each
+//of the given streams, as Stream.Earliest would. It is otherwise the same as
+//LatestValues.
+func (b *BTrDB) EarliestValues(ctx context.Context, streams []*Stream, after int64) []NearestResult {
+	return b.nearestValues(ctx, streams, after, false)
+}
+
+func (b *BTrDB) nearestValues(ctx context.Context, streams []*Stream, time int64, backward bool) []NearestResult {
+	rv := make([]NearestResult, len(streams))
+	for i, s := range streams {
+		rv[i].Stream = s
+	}
+	b.forEachByEndpoint(ctx, streams, func(ep *Endpoint, i int) {
+		r := &rv[i]
+		if ep != nil {
+			r.Point, r.Version, r.Err = ep.Nearest(ctx, r.Stream.uuid, time, LatestVersion, backward)
+			if !b.TestEpError(ep, r.Err) {
+				return
+			}
+		}
+		//Fall back to the per stream retry logic
+		r.Point, r.Version, r.Err = r.Stream.Nearest(ctx, time, LatestVersion, backward)
+	})
+	return rv
+}
+
+//forEachByEndpoint groups the streams by the endpoint that serves them and
+//calls fn with the index of each stream, making up to endpointParallelism
+//concurrent calls per endpoint. It returns when all calls have returned. The
+//endpoint is nil if it could not be found.
+func (b *BTrDB) forEachByEndpoint(ctx context.Context, streams []*Stream, fn func(ep *Endpoint, i int)) {
+	groups := make(map[*Endpoint][]int)
+	for i, s := range streams {
+		//If the endpoint cannot be found now, fn is expected to retry
+		ep, _ := b.ReadEndpointFor(ctx, s.uuid)
+		groups[ep] = append(groups[ep], i)
+	}
+	wg := sync.WaitGroup{}
+	for ep, idxs := range groups {
+		work := make(chan int, len(idxs))
+		for _, i := range idxs {
+			work <- i
+		}
+		close(work)
+		nworkers := endpointParallelism
+		if len(idxs) < nworkers {
+			nworkers = len(idxs)
+		}
+		wg.Add(nworkers)
+		for w := 0; w < nworkers; w++ {
+			go func(ep *Endpoint) {
+				for i := range work {
+					fn(ep, i)
+				}
+				wg.Done()
+			}(ep)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,249 @@
+package btrdb
+
+import (
+	"container/list"
+	"sync"
+)
+
+//Query results larger than this fraction of the cache are not cached, so that
+//one large query does not evict everything else
+const cacheMaxEntryFraction = 8
+
+//Approximate sizes of cached values, in bytes
+const (
+	rawPointSize   = 16
+	statPointSize  = 48
+	cacheEntrySize = 128
+)
+
+const (
+	cacheKindRaw = iota
+	cacheKindAligned
+	cacheKindWindows
+)
+
+//CacheStats contains statistics about the query result cache
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	//The number of cached query results
+	Entries int
+	//The approximate memory used by the cached results
+	Bytes int64
+}
+
+type cacheKey struct {
+	uuid    [16]byte
+	kind    int
+	start   int64
+	end     int64
+	width   uint64
+	depth   uint8
+	version uint64
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	raw   []RawPoint
+	stats []StatPoint
+	size  int64
+	//The size above which the entry is not cached, and whether it was exceeded
+	limit int64
+	full  bool
+}
+
+//queryCache is an LRU cache of query results at concrete versions. As the
+//data at a given version never changes, entries never need to be invalidated.
+type queryCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	lru      *list.List
+	entries  map[cacheKey]*list.Element
+	stats    CacheStats
+}
+
+//EnableCache enables a client side cache of RawValues, Windows and AlignedWindows
+//results, using at most approximately maxBytes of memory. Only queries at a
+//concrete version (not LatestVersion) are cached, as the results at a given
+//version never change. The least recently used results are evicted first.
+//Calling EnableCache again replaces the cache with an empty one, and a maxBytes
+//of zero disables the cache.
+func (b *BTrDB) EnableCache(maxBytes int64) {
+	if maxBytes <= 0 {
+		b.cache.Store((*queryCache)(nil))
+		return
+	}
+	b.cache.Store(&queryCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[cacheKey]*list.Element),
+	})
+}
+
+//CacheStats returns the statistics of the query result cache. All values are
+//zero if the cache is not enabled.
+func (b *BTrDB) CacheStats() CacheStats {
+	c := b.getCache()
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rv := c.stats
+	rv.Entries = c.lru.Len()
+	rv.Bytes = c.bytes
+	return rv
+}
+
+func (b *BTrDB) getCache() *queryCache {
+	c, _ := b.cache.Load().(*queryCache)
+	return c
+}
+
+func (c *queryCache) get(k cacheKey) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[k]
+	if !ok {
+		c.stats.Misses++
+		return nil
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry)
+}
+
+func (c *queryCache) put(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[e.key]; ok {
+		//A concurrent identical query got there first
+		return
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.bytes += e.size
+	for c.bytes > c.maxBytes {
+		el := c.lru.Back()
+		old := el.Value.(*cacheEntry)
+		c.lru.Remove(el)
+		delete(c.entries, old.key)
+		c.bytes -= old.size
+		c.stats.Evictions++
+	}
+}
+
+func (c *queryCache) maxEntrySize() int64 {
+	return c.maxBytes / cacheMaxEntryFraction
+}
+
+//add accounts for a value of the given size being added to the entry, returning
+//false if the entry has become too large to cache and the value should be dropped
+func (e *cacheEntry) add(size int64) bool {
+	if e.full {
+		return false
+	}
+	e.size += size
+	if e.size > e.limit {
+		e.full = true
+		e.raw = nil
+		e.stats = nil
+		return false
+	}
+	return true
+}
+
+//query serves a query from the cache, or performs it with fetch and caches the
+//result if it completes without error. replay writes the values of a cached
+//entry, forward writes the values of the query being performed (adding them to
+//the entry while e.add allows), and closeValues closes the value channel.
+func (c *queryCache) query(k cacheKey, fetch func() (chan uint64, chan error), replay func(e *cacheEntry), forward func(e *cacheEntry), closeValues func()) (chan uint64, chan error) {
+	rvv := make(chan uint64, 1)
+	rve := make(chan error, 1)
+	if e := c.get(k); e != nil {
+		rvv <- k.version
+		close(rvv)
+		close(rve)
+		go func() {
+			replay(e)
+			closeValues()
+		}()
+		return rvv, rve
+	}
+	verc, errc := fetch()
+	go func() {
+		//The version is written before any values, or the channel is closed on error
+		if ver, ok := <-verc; ok {
+			rvv <- ver
+		}
+		close(rvv)
+		e := &cacheEntry{key: k, size: cacheEntrySize, limit: c.maxEntrySize()}
+		forward(e)
+		err := <-errc
+		if !e.full && err == nil {
+			c.put(e)
+		}
+		closeValues()
+		if err != nil {
+			rve <- err
+		}
+		close(rve)
+	}()
+	return rvv, rve
+}
+
+//rawValues serves a RawValues query from the cache, or performs it with fetch
+//and caches the result if it completes without error
+func (c *queryCache) rawValues(k cacheKey, fetch func() (chan RawPoint, chan uint64, chan error)) (chan RawPoint, chan uint64, chan error) {
+	rvc := make(chan RawPoint, 100)
+	var vals chan RawPoint
+	rvv, rve := c.query(k, func() (chan uint64, chan error) {
+		var verc chan uint64
+		var errc chan error
+		vals, verc, errc = fetch()
+		return verc, errc
+	}, func(e *cacheEntry) {
+		for _, v := range e.raw {
+			rvc <- v
+		}
+	}, func(e *cacheEntry) {
+		for v := range vals {
+			if e.add(rawPointSize) {
+				e.raw = append(e.raw, v)
+			}
+			rvc <- v
+		}
+	}, func() { close(rvc) })
+	return rvc, rvv, rve
+}
+
+//statPoints is like rawValues, but for window queries
+func (c *queryCache) statPoints(k cacheKey, fetch func() (chan StatPoint, chan uint64, chan error)) (chan StatPoint, chan uint64, chan error) {
+	rvc := make(chan StatPoint, 100)
+	var vals chan StatPoint
+	rvv, rve := c.query(k, func() (chan uint64, chan error) {
+		var verc chan uint64
+		var errc chan error
+		vals, verc, errc = fetch()
+		return verc, errc
+	}, func(e *cacheEntry) {
+		for _, v := range e.stats {
+			rvc <- v
+		}
+	}, func(e *cacheEntry) {
+		for v := range vals {
+			if e.add(statPointSize) {
+				e.stats = append(e.stats, v)
+			}
+			rvc <- v
+		}
+	}, func() { close(rvc) })
+	return rvc, rvv, rve
+}
+
+func newCacheKey(s *Stream, kind int, start int64, end int64, width uint64, depth uint8, version uint64) cacheKey {
+	k := cacheKey{kind: kind, start: start, end: end, width: width, depth: depth, version: version}
+	copy(k.uuid[:], s.uuid)
+	return k
+}
@@ -0,0 +1,109 @@
+package btrdb
+
+import (
+	"context"
+	"time"
+)
+
+//CalendarUnit is the length of the periods returned by CalendarWindows
+type CalendarUnit int
+
+const (
+	//CalendarDay is a local calendar day, starting at midnight
+	CalendarDay = CalendarUnit(iota)
+	//CalendarWeek is a local calendar week, starting at midnight on Monday
+	CalendarWeek
+	//CalendarMonth is a local calendar month, starting at midnight on the first
+	CalendarMonth
+)
+
+//periodStart returns the start of the calendar period containing t
+func (u CalendarUnit) periodStart(t time.Time) time.Time {
+	y, m, d := t.Date()
+	switch u {
+	case CalendarWeek:
+		//Weekday() has Sunday as 0, weeks start on Monday
+		off := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-off, 0, 0, 0, 0, t.Location())
+	case CalendarMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	}
+}
+
+//next returns the start of the calendar period following the one starting at t
+func (u CalendarUnit) next(t time.Time) time.Time {
+	y, m, d := t.Date()
+	switch u {
+	case CalendarWeek:
+		return time.Date(y, m, d+7, 0, 0, 0, 0, t.Location())
+	case CalendarMonth:
+		return time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+	}
+}
+
+//calendarBoundaries returns the boundaries of the calendar periods intersecting
+//[start, end). The first and last boundaries are start and end.
+func calendarBoundaries(start int64, end int64, unit CalendarUnit, loc *time.Location) []int64 {
+	rv := []int64{start}
+	b := unit.next(unit.periodStart(time.Unix(0, start).In(loc)))
+	for b.UnixNano() < end {
+		rv = append(rv, b.UnixNano())
+		b = unit.next(b)
+	}
+	return append(rv, end)
+}
+
+//CalendarWindows returns one StatPoint per local calendar day, week or month in
+//the given location, which handles daylight savings changes and months of
+//different lengths. The first and last periods are truncated to [start, end), and
+//the Time of each StatPoint is the start of its (possibly truncated) period.
+//Internally, each run of equal length periods is fetched with a single Windows
+//query, and the depth parameter is as for Windows. All of the queries are pinned
+//to the same version. The StatPoint channel must be fully consumed.
+func (s *Stream) CalendarWindows(ctx context.Context, start int64, end int64, unit CalendarUnit, loc *time.Location, depth uint8, version uint64) (chan StatPoint, chan uint64, chan error) {
+	rvc := make(chan StatPoint, 100)
+	rvv := make(chan uint64, 1)
+	rve := make(chan error, 1)
+	if end <= start || loc == nil {
+		close(rvc)
+		close(rvv)
+		rve <- ErrorWrongArgs
+		close(rve)
+		return rvc, rvv, rve
+	}
+	go func() {
+		bounds := calendarBoundaries(start, end, unit, loc)
+		wroteVer := false
+		var err error
+		for i := 0; i < len(bounds)-1 && err == nil; {
+			//Find the run of periods having the same length
+			width := bounds[i+1] - bounds[i]
+			j := i + 1
+			for j < len(bounds)-1 && bounds[j+1]-bounds[j] == width {
+				j++
+			}
+			vals, verc, errc := s.Windows(ctx, bounds[i], bounds[j], uint64(width), depth, version)
+			for v := range vals {
+				rvc <- v
+			}
+			err = <-errc
+			if ver, ok := <-verc; ok && !wroteVer {
+				version = ver
+				rvv <- ver
+				wroteVer = true
+			}
+			i = j
+		}
+		close(rvc)
+		close(rvv)
+		if err != nil {
+			rve <- err
+		}
+		close(rve)
+	}()
+	return rvc, rvv, rve
+}
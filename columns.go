@@ -0,0 +1,164 @@
+package btrdb
+
+import (
+	"context"
+	"io"
+
+	pb "github.com/BTrDB/btrdb/v5/v5api"
+	"github.com/pborman/uuid"
+)
+
+//StatColumns holds the results of a window query in columnar form. Element i
+//of each slice belongs to the same window.
+type StatColumns struct {
+	Time   []int64
+	Min    []float64
+	Mean   []float64
+	Max    []float64
+	Count  []uint64
+	StdDev []float64
+}
+
+//Len returns the number of windows held
+func (c *StatColumns) Len() int {
+	return len(c.Time)
+}
+
+//Reset truncates all the columns to zero length, retaining the allocated
+//storage so that the StatColumns can be reused as a buffer
+func (c *StatColumns) Reset() {
+	c.Time = c.Time[:0]
+	c.Min = c.Min[:0]
+	c.Mean = c.Mean[:0]
+	c.Max = c.Max[:0]
+	c.Count = c.Count[:0]
+	c.StdDev = c.StdDev[:0]
+}
+
+func (c *StatColumns) appendPB(vals []*pb.StatPoint) {
+	for _, x := range vals {
+		c.Time = append(c.Time, x.Time)
+		c.Min = append(c.Min, x.Min)
+		c.Mean = append(c.Mean, x.Mean)
+		c.Max = append(c.Max, x.Max)
+		c.Count = append(c.Count, x.Count)
+		c.StdDev = append(c.StdDev, x.Stddev)
+	}
+}
+
+//RawValuesTV is a low level function, rather use Stream.RawValuesTV(). The
+//values are appended to times and values, which are returned even if an
+//error occurs part way through. A version of zero is returned if no response
+//was received.
+func (b *Endpoint) RawValuesTV(ctx context.Context, uu uuid.UUID, start int64, end int64, version uint64, times []int64, values []float64) ([]int64, []float64, uint64, error) {
+	ictx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rv, err := b.g.RawValues(ictx, &pb.RawValuesParams{
+		Uuid:         uu,
+		Start:        start,
+		End:          end,
+		VersionMajor: version,
+	})
+	if err != nil {
+		return times, values, 0, err
+	}
+	var ver uint64
+	for {
+		rawv, err := rv.Recv()
+		if err == io.EOF {
+			return times, values, ver, nil
+		}
+		if err != nil {
+			return times, values, ver, err
+		}
+		if rawv.Stat != nil {
+			return times, values, ver, &CodedError{rawv.Stat}
+		}
+		ver = rawv.VersionMajor
+		for _, x := range rawv.Values {
+			times = append(times, x.Time)
+			values = append(values, x.Value)
+		}
+	}
+}
+
+//WindowsColumns is a low level function, rather use Stream.WindowsColumns().
+//The windows are appended to cols. A version of zero is returned if no response
+//was received.
+func (b *Endpoint) WindowsColumns(ctx context.Context, uu uuid.UUID, start int64, end int64, width uint64, depth uint8, version uint64, cols *StatColumns) (uint64, error) {
+	ictx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rv, err := b.g.Windows(ictx, &pb.WindowsParams{
+		Uuid:         uu,
+		Start:        start,
+		End:          end,
+		Width:        width,
+		Depth:        uint32(depth),
+		VersionMajor: version,
+	})
+	if err != nil {
+		return 0, err
+	}
+	var ver uint64
+	for {
+		rawv, err := rv.Recv()
+		if err == io.EOF {
+			return ver, nil
+		}
+		if err != nil {
+			return ver, err
+		}
+		if rawv.Stat != nil {
+			return ver, &CodedError{rawv.Stat}
+		}
+		ver = rawv.VersionMajor
+		cols.appendPB(rawv.Values)
+	}
+}
+
+//RawValuesTV reads raw values from BTrDB into two parallel slices, one
+//containing times and the other containing values, the counterpart of InsertTV.
+//This avoids the per-point overhead of the channel API and is appropriate for
+//very large reads. The values are appended to times[:0] and values[:0], so
+//existing slices can be passed in to be reused; nil is also fine. The version
+//of the stream used to satisfy the query is returned. Like RawValues, the read
+//is resumed if the endpoint fails part way through.
+func (s *Stream) RawValuesTV(ctx context.Context, start int64, end int64, version uint64, times []int64, values []float64) ([]int64, []float64, uint64, error) {
+	times = times[:0]
+	values = values[:0]
+	version, err := s.resumeRead(ctx, start, end, version, func(ep *Endpoint, start int64, version uint64) (int64, uint64, error) {
+		var ver uint64
+		var err error
+		times, values, ver, err = ep.RawValuesTV(ctx, s.uuid, start, end, version, times, values)
+		if len(times) > 0 {
+			start = times[len(times)-1] + 1
+		}
+		return start, ver, err
+	})
+	if err != nil {
+		return times, values, 0, err
+	}
+	return times, values, version, nil
+}
+
+//WindowsColumns returns the same windows as Windows(), but in columnar form.
+//If cols is not nil it is reset and reused, otherwise a new StatColumns is
+//allocated. The version of the stream used to satisfy the query is returned.
+//Like Windows, the read is resumed if the endpoint fails part way through.
+func (s *Stream) WindowsColumns(ctx context.Context, start int64, end int64, width uint64, depth uint8, version uint64, cols *StatColumns) (*StatColumns, uint64, error) {
+	if cols == nil {
+		cols = &StatColumns{}
+	}
+	cols.Reset()
+	version, err := s.resumeRead(ctx, start, end, version, func(ep *Endpoint, start int64, version uint64) (int64, uint64, error) {
+		ver, err := ep.WindowsColumns(ctx, s.uuid, start, end, width, depth, version, cols)
+		if n := cols.Len(); n > 0 {
+			start = cols.Time[n-1] + int64(width)
+		}
+		return start, ver, err
+	})
+	if err != nil {
+		return cols, 0, err
+	}
+	return cols, version, nil
+}
@@ -33,6 +33,14 @@ var ErrorClusterDegraded = &CodedError{&pb.Status{Code: 419, Msg: "Cluster is de
 //ErrorWrongArgs is returned from API functions if the parameters are nonsensical
 var ErrorWrongArgs = &CodedError{&pb.Status{Code: 421, Msg: "Invalid Arguments"}}
 
+//ErrorVersionCompacted is returned from Snapshot reads if the pinned version
+//has been compacted and can no longer be accessed
+var ErrorVersionCompacted = &CodedError{&pb.Status{Code: 427, Msg: "Version has been compacted"}}
+
+//ErrorNoPoints is returned from Quantiles and Histogram if there are no points
+//in the range. It has the same code as the server error for Nearest.
+var ErrorNoPoints = &CodedError{&pb.Status{Code: 401, Msg: "No points in range"}}
+
 //ErrorNoSuchStream is returned if an operation is attempted on a stream when
 //it does not exist.
 //var ErrorNoSuchStream = &CodedError{&pb.Status{Code: 404, Msg: "No such stream"}}
@@ -60,6 +68,9 @@ type BTrDB struct {
 	resyncMu sync.Mutex
 	//Incremented every time there is a resync
 	numResyncs int64
+
+	//The optional query result cache, see EnableCache
+	cache atomic.Value
 }
 
 func newBTrDB() *BTrDB {
@@ -0,0 +1,164 @@
+package btrdb
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+
+	pb "github.com/BTrDB/btrdb/v5/v5api"
+	"github.com/pborman/uuid"
+)
+
+//CSVQueryType determines which kind of query is used to generate each row of a CSV
+type CSVQueryType int
+
+const (
+	//CSVAlignedWindows generates a row per aligned window, WindowSize is the pointwidth
+	CSVAlignedWindows = CSVQueryType(iota)
+	//CSVWindows generates a row per window, WindowSize is the width in nanoseconds
+	CSVWindows
+	//CSVRaw generates a row per raw point
+	CSVRaw
+)
+
+//CSVStream configures a single stream in a CSV
+type CSVStream struct {
+	UUID uuid.UUID
+	//The column label for this stream
+	Label string
+	//The version of the stream to query, or LatestVersion
+	Version uint64
+}
+
+//CSVConfig describes the CSV file to generate
+type CSVConfig struct {
+	QueryType CSVQueryType
+	//Start time (inclusive) and end time (exclusive) in nanoseconds
+	StartTime int64
+	EndTime   int64
+	//The window width, or the pointwidth for CSVAlignedWindows. Unused for CSVRaw
+	WindowSize uint64
+	//The depth for CSVWindows, see Stream.Windows
+	Depth uint8
+	//If true, the version of each stream is included in the header
+	IncludeVersions bool
+	Streams         []CSVStream
+}
+
+//toPB returns ErrorWrongArgs if the query type is unknown
+func (cfg *CSVConfig) toPB() (*pb.GenerateCSVParams, error) {
+	var qt pb.GenerateCSVParams_QueryType
+	switch cfg.QueryType {
+	case CSVAlignedWindows:
+		qt = pb.GenerateCSVParams_ALIGNED_WINDOWS_QUERY
+	case CSVWindows:
+		qt = pb.GenerateCSVParams_WINDOWS_QUERY
+	case CSVRaw:
+		qt = pb.GenerateCSVParams_RAW_QUERY
+	default:
+		return nil, ErrorWrongArgs
+	}
+	streams := make([]*pb.StreamCSVConfig, len(cfg.Streams))
+	for i, s := range cfg.Streams {
+		streams[i] = &pb.StreamCSVConfig{
+			Version: s.Version,
+			Label:   s.Label,
+			Uuid:    s.UUID,
+		}
+	}
+	return &pb.GenerateCSVParams{
+		QueryType:       qt,
+		StartTime:       cfg.StartTime,
+		EndTime:         cfg.EndTime,
+		WindowSize:      cfg.WindowSize,
+		Depth:           uint32(cfg.Depth),
+		IncludeVersions: cfg.IncludeVersions,
+		Streams:         streams,
+	}, nil
+}
+
+//GenerateCSV is a low level function, rather use BTrDB.GenerateCSV(). It returns
+//the number of rows (including the header) written to w, which has not been flushed.
+func (b *Endpoint) GenerateCSV(ctx context.Context, cfg *CSVConfig, w *csv.Writer) (int, error) {
+	params, err := cfg.toPB()
+	if err != nil {
+		return 0, err
+	}
+	ictx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rv, err := b.g.GenerateCSV(ictx, params)
+	if err != nil {
+		return 0, err
+	}
+	rows := 0
+	for {
+		r, err := rv.Recv()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return rows, err
+		}
+		if r.Stat != nil {
+			return rows, &CodedError{r.Stat}
+		}
+		if err := w.Write(r.Row); err != nil {
+			return rows, err
+		}
+		rows++
+	}
+}
+
+//GenerateCSV executes the given query on all the streams in the config and writes
+//the resulting header and rows to w as CSV. The query is sent to the endpoint that
+//serves the most of the streams. If the endpoint fails before any rows have been
+//written, the query is retried, otherwise the error is returned.
+func (b *BTrDB) GenerateCSV(ctx context.Context, cfg *CSVConfig, w io.Writer) error {
+	if len(cfg.Streams) == 0 || cfg.EndTime <= cfg.StartTime {
+		return ErrorWrongArgs
+	}
+	if _, err := cfg.toPB(); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	var ep *Endpoint
+	var err error
+	for b.TestEpError(ep, err) {
+		ep, err = b.csvEndpointFor(ctx, cfg)
+		if err != nil {
+			continue
+		}
+		var rows int
+		rows, err = ep.GenerateCSV(ctx, cfg, cw)
+		if err != nil && rows > 0 {
+			//Some of the CSV has been written so we cannot retry
+			cw.Flush()
+			b.TestEpError(ep, err)
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+//csvEndpointFor returns the endpoint that serves the largest number of the streams
+//in the config. This minimizes the amount of data the server has to fetch from
+//other nodes.
+func (b *BTrDB) csvEndpointFor(ctx context.Context, cfg *CSVConfig) (*Endpoint, error) {
+	counts := make(map[*Endpoint]int)
+	var best *Endpoint
+	for _, s := range cfg.Streams {
+		ep, err := b.ReadEndpointFor(ctx, s.UUID)
+		if err != nil {
+			return nil, err
+		}
+		counts[ep]++
+		if best == nil || counts[ep] > counts[best] {
+			best = ep
+		}
+	}
+	return best, nil
+}
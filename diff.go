@@ -0,0 +1,121 @@
+package btrdb
+
+import (
+	"context"
+	"sort"
+)
+
+//DiffKind is the kind of change described by a DiffEntry
+type DiffKind int
+
+const (
+	//DiffAdded means the point exists only in the newer version
+	DiffAdded = DiffKind(iota)
+	//DiffRemoved means the point exists only in the older version
+	DiffRemoved
+	//DiffModified means the point exists in both versions with different values
+	DiffModified
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffModified:
+		return "modified"
+	}
+	return "unknown"
+}
+
+//DiffEntry is a single point that differs between two versions of a stream
+type DiffEntry struct {
+	Kind DiffKind
+	Time int64
+	//The value in the older version, unused for DiffAdded
+	OldValue float64
+	//The value in the newer version, unused for DiffRemoved
+	NewValue float64
+}
+
+//Diff returns the points that differ between two versions of the stream, in
+//time order. The changed ranges are found with Changes, and the raw values of
+//both versions are read concurrently for each range and merged, so large ranges
+//are not buffered in memory. The version used as toVersion (which is resolved if
+//it is LatestVersion) is written to the version channel. The DiffEntry channel
+//must be fully consumed.
+func (s *Stream) Diff(ctx context.Context, fromVersion uint64, toVersion uint64) (chan DiffEntry, chan uint64, chan error) {
+	rvc := make(chan DiffEntry, 100)
+	rvv := make(chan uint64, 1)
+	rve := make(chan error, 1)
+	go func() {
+		err := s.diff(ctx, fromVersion, toVersion, rvc, rvv)
+		close(rvc)
+		close(rvv)
+		if err != nil {
+			rve <- err
+		}
+		close(rve)
+	}()
+	return rvc, rvv, rve
+}
+
+func (s *Stream) diff(ctx context.Context, fromVersion uint64, toVersion uint64, rvc chan DiffEntry, rvv chan uint64) error {
+	ranges := []ChangedRange{}
+	crc, verc, errc := s.Changes(ctx, fromVersion, toVersion, 0)
+	for cr := range crc {
+		ranges = append(ranges, cr)
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+	if ver, ok := <-verc; ok {
+		toVersion = ver
+	}
+	rvv <- toVersion
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	var done int64 = MinimumTime
+	for _, r := range ranges {
+		//Do not report points in overlapping ranges twice
+		start := r.Start
+		if start < done {
+			start = done
+		}
+		if start >= r.End {
+			continue
+		}
+		if err := s.diffRange(ctx, start, r.End, fromVersion, toVersion, rvc); err != nil {
+			return err
+		}
+		done = r.End
+	}
+	return nil
+}
+
+func (s *Stream) diffRange(ctx context.Context, start int64, end int64, fromVersion uint64, toVersion uint64, rvc chan DiffEntry) error {
+	oldc, _, olderr := s.RawValues(ctx, start, end, fromVersion)
+	newc, _, newerr := s.RawValues(ctx, start, end, toVersion)
+	po, oko := <-oldc
+	pn, okn := <-newc
+	for oko || okn {
+		switch {
+		case okn && (!oko || pn.Time < po.Time):
+			rvc <- DiffEntry{Kind: DiffAdded, Time: pn.Time, NewValue: pn.Value}
+			pn, okn = <-newc
+		case oko && (!okn || po.Time < pn.Time):
+			rvc <- DiffEntry{Kind: DiffRemoved, Time: po.Time, OldValue: po.Value}
+			po, oko = <-oldc
+		default:
+			if po.Value != pn.Value {
+				rvc <- DiffEntry{Kind: DiffModified, Time: po.Time, OldValue: po.Value, NewValue: pn.Value}
+			}
+			po, oko = <-oldc
+			pn, okn = <-newc
+		}
+	}
+	if err := <-olderr; err != nil {
+		return err
+	}
+	return <-newerr
+}
@@ -0,0 +1,92 @@
+package btrdb
+
+import (
+	"context"
+)
+
+//The number of pointwidths between a WindowNode and its children. BTrDB tree
+//nodes have 64 children, so this matches the structure of the tree.
+const drillStep = 6
+
+//WindowNode is an aligned window that can be drilled into to obtain finer
+//windows covering the same time. All the nodes obtained from the same call to
+//Stream.DrillDown are pinned to the same version of the stream, so each level is
+//consistent with the others.
+type WindowNode struct {
+	StatPoint
+	//The window is 2^PointWidth nanoseconds long
+	PointWidth uint8
+
+	s       *Stream
+	version uint64
+}
+
+//DrillDown returns the root level of a drill down session: the aligned windows
+//in [start, end) at the given pointwidth, as for AlignedWindows. The version is
+//resolved once and used for all subsequent fetches from the returned nodes and
+//their descendants, and is also returned.
+func (s *Stream) DrillDown(ctx context.Context, start int64, end int64, pointwidth uint8, version uint64) ([]*WindowNode, uint64, error) {
+	if version == LatestVersion {
+		ver, err := s.Version(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		version = ver
+	}
+	rv, err := s.windowNodes(ctx, start, end, pointwidth, version)
+	if err != nil {
+		return nil, 0, err
+	}
+	return rv, version, nil
+}
+
+func (s *Stream) windowNodes(ctx context.Context, start int64, end int64, pointwidth uint8, version uint64) ([]*WindowNode, error) {
+	rv := []*WindowNode{}
+	windows, _, errc := s.AlignedWindows(ctx, start, end, pointwidth, version)
+	for w := range windows {
+		rv = append(rv, &WindowNode{StatPoint: w, PointWidth: pointwidth, s: s, version: version})
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+//Version returns the version of the stream that the node was read at
+func (n *WindowNode) Version() uint64 {
+	return n.version
+}
+
+//End returns the (exclusive) end time of the window
+func (n *WindowNode) End() int64 {
+	return n.Time + 1<<n.PointWidth
+}
+
+//IsLeaf returns true if the window is small enough that it should be drilled
+//into with Points rather than Children
+func (n *WindowNode) IsLeaf() bool {
+	return n.PointWidth <= drillStep
+}
+
+//Children returns the nonempty windows at the next finer level of the tree that
+//make up this window. Leaf windows have no children, use Points instead.
+func (n *WindowNode) Children(ctx context.Context) ([]*WindowNode, error) {
+	if n.IsLeaf() {
+		return nil, nil
+	}
+	return n.s.windowNodes(ctx, n.Time, n.End(), n.PointWidth-drillStep, n.version)
+}
+
+//Points returns the raw points within the window. This can be used at any
+//level, but is intended for leaves.
+func (n *WindowNode) Points(ctx context.Context) ([]RawPoint, error) {
+	rv := []RawPoint{}
+	vals, _, errc := n.s.RawValues(ctx, n.Time, n.End(), n.version)
+	for v := range vals {
+		rv = append(rv, v)
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
@@ -435,8 +435,7 @@ func streamFromLookupResult(lr *pb.StreamDescriptor, b *BTrDB) *Stream {
 	return rv
 }
 
-//LookupStreams is a low level function, rather use BTrDB.LookupStreams()
-func (b *Endpoint) LookupStreams(ctx context.Context, collection string, isCollectionPrefix bool, tags map[string]*string, annotations map[string]*string, patchDB *BTrDB) (chan *Stream, chan error) {
+func lookupStreamsParams(collection string, isCollectionPrefix bool, tags map[string]*string, annotations map[string]*string) *pb.LookupStreamsParams {
 	ltags := []*pb.KeyOptValue{}
 	for k, v := range tags {
 		kop := &pb.KeyOptValue{
@@ -457,12 +456,17 @@ func (b *Endpoint) LookupStreams(ctx context.Context, collection string, isColle
 		}
 		lanns = append(lanns, kop)
 	}
-	params := &pb.LookupStreamsParams{
+	return &pb.LookupStreamsParams{
 		Collection:         collection,
 		IsCollectionPrefix: isCollectionPrefix,
 		Tags:               ltags,
 		Annotations:        lanns,
 	}
+}
+
+//LookupStreams is a low level function, rather use BTrDB.LookupStreams()
+func (b *Endpoint) LookupStreams(ctx context.Context, collection string, isCollectionPrefix bool, tags map[string]*string, annotations map[string]*string, patchDB *BTrDB) (chan *Stream, chan error) {
+	params := lookupStreamsParams(collection, isCollectionPrefix, tags, annotations)
 	rv, err := b.g.LookupStreams(ctx, params)
 	rvc := make(chan *Stream, 100)
 	rve := make(chan error, 1)
@@ -0,0 +1,228 @@
+package btrdb
+
+import (
+	"context"
+	"sort"
+)
+
+//Windows with at most this many points are read as raw values rather than
+//being refined further
+const exceedRawThreshold = 4096
+
+//Predicate is a condition on the value of a point, used by FindExceedances.
+//Construct it with Above, Below or Outside.
+type Predicate struct {
+	lo, hi float64
+	kind   int
+}
+
+const (
+	predAbove = iota
+	predBelow
+	predOutside
+)
+
+//Above returns a Predicate that holds for values greater than threshold
+func Above(threshold float64) Predicate {
+	return Predicate{kind: predAbove, hi: threshold}
+}
+
+//Below returns a Predicate that holds for values less than threshold
+func Below(threshold float64) Predicate {
+	return Predicate{kind: predBelow, lo: threshold}
+}
+
+//Outside returns a Predicate that holds for values less than lo or greater than hi
+func Outside(lo float64, hi float64) Predicate {
+	return Predicate{kind: predOutside, lo: lo, hi: hi}
+}
+
+//Holds returns true if the predicate holds for the given value
+func (p Predicate) Holds(v float64) bool {
+	switch p.kind {
+	case predAbove:
+		return v > p.hi
+	case predBelow:
+		return v < p.lo
+	default:
+		return v < p.lo || v > p.hi
+	}
+}
+
+//mayHold returns true if the predicate could hold for some value in [min, max]
+func (p Predicate) mayHold(min float64, max float64) bool {
+	switch p.kind {
+	case predAbove:
+		return max > p.hi
+	case predBelow:
+		return min < p.lo
+	default:
+		return min < p.lo || max > p.hi
+	}
+}
+
+//alwaysHolds returns true if the predicate holds for every value in [min, max]
+func (p Predicate) alwaysHolds(min float64, max float64) bool {
+	switch p.kind {
+	case predAbove:
+		return min > p.hi
+	case predBelow:
+		return max < p.lo
+	default:
+		return max < p.lo || min > p.hi
+	}
+}
+
+//Exceedance is a maximal interval during which a predicate held for every point
+type Exceedance struct {
+	//The time of the first point in the interval
+	Start int64
+	//The time of the last point in the interval (inclusive)
+	End int64
+}
+
+const (
+	segNone = iota
+	segAll
+	segRaw
+)
+
+//segment is a part of the stream that has been classified by FindExceedances
+type segment struct {
+	start, end int64
+	kind       int
+	points     []RawPoint
+}
+
+//FindExceedances returns the intervals within [start, end) during which the
+//predicate held, in time order. Rather than reading all the raw values, it
+//descends the tree using AlignedWindows, pruning windows whose min and max show
+//that the predicate cannot hold for any of their points, or holds for all of
+//them. Only the remaining windows are refined, and raw values are read once
+//they are small. The edges of each interval are exact. All queries are pinned
+//to the same version.
+func (s *Stream) FindExceedances(ctx context.Context, start int64, end int64, pred Predicate, version uint64) ([]Exceedance, error) {
+	if end <= start {
+		return nil, ErrorWrongArgs
+	}
+	segs := []segment{}
+	rawWindows := []segment{}
+	//classify clips the windows to [start, end), as the last may extend past it
+	classify := func(from int64, to int64, windows []StatPoint, pw uint8) []int64 {
+		candidates := []int64{}
+		width := int64(1) << pw
+		for _, w := range windows {
+			ws, we := w.Time, w.Time+width
+			if we <= start || ws >= end {
+				continue
+			}
+			inside := ws >= start && we <= end
+			switch {
+			case !pred.mayHold(w.Min, w.Max):
+				segs = append(segs, segment{start: ws, end: we, kind: segNone})
+			case inside && pred.alwaysHolds(w.Min, w.Max):
+				segs = append(segs, segment{start: ws, end: we, kind: segAll})
+			case w.Count <= exceedRawThreshold || pw == 0:
+				rawWindows = append(rawWindows, segment{start: ws, end: we, kind: segRaw})
+			default:
+				candidates = append(candidates, ws)
+			}
+		}
+		return candidates
+	}
+
+	pw := countPointWidth(start, end, descentRootWindows)
+	windows, version, err := s.alignedWindowList(ctx, start, end, pw, version)
+	if err != nil {
+		return nil, err
+	}
+	candidates := classify(start, end, windows, pw)
+	if err := s.descend(ctx, candidates, pw, 0, version, classify); err != nil {
+		return nil, err
+	}
+	for _, rw := range rawWindows {
+		rs, re := rw.start, rw.end
+		if rs < start {
+			rs = start
+		}
+		if re > end {
+			re = end
+		}
+		vals, _, errc := s.RawValues(ctx, rs, re, version)
+		for v := range vals {
+			rw.points = append(rw.points, v)
+		}
+		if err := <-errc; err != nil {
+			return nil, err
+		}
+		segs = append(segs, rw)
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].start < segs[j].start })
+	return s.sweepExceedances(ctx, segs, pred, version)
+}
+
+//sweepExceedances joins the classified segments into intervals, finding the
+//exact edges of intervals that begin or end in a window where the predicate
+//always holds with Nearest
+func (s *Stream) sweepExceedances(ctx context.Context, segs []segment, pred Predicate, version uint64) ([]Exceedance, error) {
+	rv := []Exceedance{}
+	inRun := false
+	var cur Exceedance
+	//If the run ends in a segAll window, this is its end, and the last point
+	//must be found
+	var openEnd int64
+	endExact := false
+	closeRun := func() error {
+		if !inRun {
+			return nil
+		}
+		inRun = false
+		if !endExact {
+			p, _, err := s.Nearest(ctx, openEnd, version, true)
+			if err != nil {
+				return err
+			}
+			cur.End = p.Time
+		}
+		rv = append(rv, cur)
+		return nil
+	}
+	for _, sg := range segs {
+		switch sg.kind {
+		case segNone:
+			if err := closeRun(); err != nil {
+				return nil, err
+			}
+		case segAll:
+			if !inRun {
+				p, _, err := s.Nearest(ctx, sg.start, version, false)
+				if err != nil {
+					return nil, err
+				}
+				cur = Exceedance{Start: p.Time}
+				inRun = true
+			}
+			openEnd = sg.end
+			endExact = false
+		case segRaw:
+			for _, p := range sg.points {
+				if !pred.Holds(p.Value) {
+					if err := closeRun(); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				if !inRun {
+					cur = Exceedance{Start: p.Time}
+					inRun = true
+				}
+				cur.End = p.Time
+				endExact = true
+			}
+		}
+	}
+	if err := closeRun(); err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
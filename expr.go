@@ -0,0 +1,378 @@
+package btrdb
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//Expression is a parsed arithmetic expression over stream variables and
+//constants, such as "(a+b+c)/3" or "a * 1.732". It supports + - * /, unary
+//minus and parentheses. Variables are identifiers that are bound to streams
+//when the expression is evaluated.
+type Expression struct {
+	root exprNode
+	vars []string
+	src  string
+}
+
+type exprNode interface {
+	eval(vals []float64) float64
+}
+
+type exprConst float64
+
+func (c exprConst) eval(vals []float64) float64 {
+	return float64(c)
+}
+
+//exprVar is an index into the variables of the expression
+type exprVar int
+
+func (v exprVar) eval(vals []float64) float64 {
+	return vals[v]
+}
+
+type exprNeg struct {
+	x exprNode
+}
+
+func (n exprNeg) eval(vals []float64) float64 {
+	return -n.x.eval(vals)
+}
+
+type exprBinary struct {
+	op   byte
+	l, r exprNode
+}
+
+func (b exprBinary) eval(vals []float64) float64 {
+	l := b.l.eval(vals)
+	r := b.r.eval(vals)
+	switch b.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	default:
+		return l / r
+	}
+}
+
+//ParseExpression parses the given expression
+func ParseExpression(src string) (*Expression, error) {
+	p := &exprParser{src: src, varidx: make(map[string]int)}
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	return &Expression{root: root, vars: p.vars, src: src}, nil
+}
+
+//Vars returns the names of the variables in the expression, in order of
+//first appearance. Do not modify the returned slice.
+func (e *Expression) Vars() []string {
+	return e.vars
+}
+
+//Eval evaluates the expression, vals[i] is the value of Vars()[i]
+func (e *Expression) Eval(vals []float64) float64 {
+	return e.root.eval(vals)
+}
+
+//String returns the source of the expression
+func (e *Expression) String() string {
+	return e.src
+}
+
+type exprParser struct {
+	src    string
+	pos    int
+	vars   []string
+	varidx map[string]int
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("expression %q: %s at position %d", p.src, fmt.Sprintf(format, args...), p.pos)
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+//peek returns the next non space character, or zero at the end of the input
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos == len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// sum := product (('+'|'-') product)*
+func (p *exprParser) parseSum() (exprNode, error) {
+	l, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for c := p.peek(); c == '+' || c == '-'; c = p.peek() {
+		p.pos++
+		r, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{op: c, l: l, r: r}
+	}
+	return l, nil
+}
+
+// product := unary (('*'|'/') unary)*
+func (p *exprParser) parseProduct() (exprNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for c := p.peek(); c == '*' || c == '/'; c = p.peek() {
+		p.pos++
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{op: c, l: l, r: r}
+	}
+	return l, nil
+}
+
+// unary := '-' unary | primary
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.peek() == '-' {
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprNeg{x}, nil
+	}
+	return p.parsePrimary()
+}
+
+// primary := number | identifier | '(' sum ')'
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, p.errorf("unexpected end of expression")
+	case c == '(':
+		p.pos++
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf("expected ')'")
+		}
+		p.pos++
+		return x, nil
+	case isDigit(c) || c == '.':
+		start := p.pos
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+			p.pos++
+			if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+				p.pos++
+			}
+			for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+				p.pos++
+			}
+		}
+		text := p.src[start:p.pos]
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			p.pos = start
+			return nil, p.errorf("invalid number %q", text)
+		}
+		return exprConst(f), nil
+	case isIdentStart(c):
+		start := p.pos
+		for p.pos < len(p.src) && (isIdentStart(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		idx, ok := p.varidx[name]
+		if !ok {
+			idx = len(p.vars)
+			p.vars = append(p.vars, name)
+			p.varidx[name] = idx
+		}
+		return exprVar(idx), nil
+	}
+	return nil, p.errorf("unexpected %q", c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+//bind returns the streams for the variables of the expression, in order
+func (e *Expression) bind(bindings map[string]*Stream) ([]*Stream, error) {
+	rv := make([]*Stream, len(e.vars))
+	for i, v := range e.vars {
+		s, ok := bindings[v]
+		if !ok {
+			return nil, fmt.Errorf("expression %q: variable %q is not bound to a stream", e.src, v)
+		}
+		rv[i] = s
+	}
+	return rv, nil
+}
+
+//EvalRawValues evaluates the expression over the raw values of the streams bound
+//to its variables. The expression is evaluated at each timestamp where all of the
+//streams have a point, other points are ignored, so this is intended for streams
+//sampled at the same times. The results may be inserted into another stream.
+//The RawPoint channel must be fully consumed.
+func (b *BTrDB) EvalRawValues(ctx context.Context, e *Expression, bindings map[string]*Stream, start int64, end int64) (chan RawPoint, chan error) {
+	streams, err := e.bind(bindings)
+	if err != nil {
+		return failedRawEval(err)
+	}
+	vals := make([]chan RawPoint, len(streams))
+	errs := make([]chan error, len(streams))
+	for i, s := range streams {
+		vals[i], _, errs[i] = s.RawValues(ctx, start, end, LatestVersion)
+	}
+	rvc := make(chan RawPoint, 100)
+	rve := make(chan error, 1)
+	go func() {
+		heads := make([]RawPoint, len(vals))
+		ok := make([]bool, len(vals))
+		for i := range vals {
+			heads[i], ok[i] = <-vals[i]
+		}
+		row := make([]float64, len(vals))
+		for {
+			//Find the latest of the heads, and advance the others to it
+			var t int64
+			done := false
+			for i := range heads {
+				if !ok[i] {
+					done = true
+					break
+				}
+				if i == 0 || heads[i].Time > t {
+					t = heads[i].Time
+				}
+			}
+			if done || len(heads) == 0 {
+				break
+			}
+			aligned := true
+			for i := range heads {
+				for ok[i] && heads[i].Time < t {
+					heads[i], ok[i] = <-vals[i]
+				}
+				if !ok[i] || heads[i].Time != t {
+					aligned = false
+				}
+			}
+			if !aligned {
+				continue
+			}
+			for i := range heads {
+				row[i] = heads[i].Value
+				heads[i], ok[i] = <-vals[i]
+			}
+			rvc <- RawPoint{Time: t, Value: e.Eval(row)}
+		}
+		//Consume the remainder of the values
+		for i := range vals {
+			for range vals[i] {
+			}
+		}
+		close(rvc)
+		var err error
+		for _, ec := range errs {
+			if serr := <-ec; serr != nil && err == nil {
+				err = serr
+			}
+		}
+		if err != nil {
+			rve <- err
+		}
+		close(rve)
+	}()
+	return rvc, rve
+}
+
+//EvalWindows evaluates the expression over the means of the windows of the
+//streams bound to its variables, as returned by BTrDB.WindowsTable. The result
+//for each window is returned as a RawPoint at the start of the window. Windows
+//where any of the streams have no data are omitted. The RawPoint channel must be
+//fully consumed.
+func (b *BTrDB) EvalWindows(ctx context.Context, e *Expression, bindings map[string]*Stream, start int64, end int64, width uint64, depth uint8) (chan RawPoint, chan error) {
+	streams, err := e.bind(bindings)
+	if err != nil {
+		return failedRawEval(err)
+	}
+	rows, _, errc := b.WindowsTable(ctx, streams, start, end, width, depth)
+	return evalTable(e, rows, errc)
+}
+
+//EvalAlignedWindows is like EvalWindows, but uses the aligned windows returned by
+//BTrDB.AlignedWindowsTable. The RawPoint channel must be fully consumed.
+func (b *BTrDB) EvalAlignedWindows(ctx context.Context, e *Expression, bindings map[string]*Stream, start int64, end int64, pointwidth uint8) (chan RawPoint, chan error) {
+	streams, err := e.bind(bindings)
+	if err != nil {
+		return failedRawEval(err)
+	}
+	rows, _, errc := b.AlignedWindowsTable(ctx, streams, start, end, pointwidth)
+	return evalTable(e, rows, errc)
+}
+
+func evalTable(e *Expression, rows chan TableRow, errc chan error) (chan RawPoint, chan error) {
+	rvc := make(chan RawPoint, 100)
+	rve := make(chan error, 1)
+	go func() {
+		vals := make([]float64, len(e.vars))
+	nextrow:
+		for row := range rows {
+			for i, c := range row.Cells {
+				if c == nil || c.Count == 0 {
+					continue nextrow
+				}
+				vals[i] = c.Mean
+			}
+			rvc <- RawPoint{Time: row.Time, Value: e.Eval(vals)}
+		}
+		close(rvc)
+		if err := <-errc; err != nil {
+			rve <- err
+		}
+		close(rve)
+	}()
+	return rvc, rve
+}
+
+func failedRawEval(err error) (chan RawPoint, chan error) {
+	rv := make(chan RawPoint)
+	close(rv)
+	errc := make(chan error, 1)
+	errc <- err
+	close(errc)
+	return rv, errc
+}
@@ -0,0 +1,83 @@
+package btrdb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseExpression(t *testing.T) {
+	cases := []struct {
+		src      string
+		env      map[string]float64
+		vars     []string
+		expected float64
+	}{
+		{"1 + 2 * 3", nil, nil, 7},
+		{"(1 + 2) * 3", nil, nil, 9},
+		{"8 - 4 - 2", nil, nil, 2},
+		{"8 / 4 / 2", nil, nil, 1},
+		{"2 * 3 - 8 / 4", nil, nil, 4},
+		{"-2 * 3", nil, nil, -6},
+		{"--2", nil, nil, 2},
+		{"3 - -2", nil, nil, 5},
+		{"-(1 + 2) * -(3)", nil, nil, 9},
+		{"((((4))))", nil, nil, 4},
+		{"1e3 + 2.5E-1 + 1.5e+1", nil, nil, 1015.25},
+		{".5 * 4", nil, nil, 2},
+		{"a - b", map[string]float64{"a": 10, "b": 4}, []string{"a", "b"}, 6},
+		{"(a+b+c)/3", map[string]float64{"a": 1, "b": 2, "c": 6}, []string{"a", "b", "c"}, 3},
+		{"b * 1.732 + a * b", map[string]float64{"a": 2, "b": 10}, []string{"b", "a"}, 37.32},
+		{" \tx_1\n+ x_1 ", map[string]float64{"x_1": 2.5}, []string{"x_1"}, 5},
+	}
+	for _, c := range cases {
+		e, err := ParseExpression(c.src)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.src, err)
+			continue
+		}
+		if len(c.vars) > 0 || len(e.Vars()) > 0 {
+			if !reflect.DeepEqual(e.Vars(), c.vars) {
+				t.Errorf("%q: got vars %q, expected %q", c.src, e.Vars(), c.vars)
+				continue
+			}
+		}
+		vals := make([]float64, len(e.Vars()))
+		for i, v := range e.Vars() {
+			vals[i] = c.env[v]
+		}
+		if got := e.Eval(vals); !closeTo(got, c.expected) {
+			t.Errorf("%q: got %v, expected %v", c.src, got, c.expected)
+		}
+		if e.String() != c.src {
+			t.Errorf("%q: String() returned %q", c.src, e.String())
+		}
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	cases := []struct {
+		src string
+		msg string
+	}{
+		{"", "unexpected end of expression at position 0"},
+		{"1 +", "unexpected end of expression at position 3"},
+		{"(1 + 2", "expected ')' at position 6"},
+		{"1 + 2)", "unexpected ')' at position 5"},
+		{"a b", "unexpected 'b' at position 2"},
+		{"2 ^ 3", "unexpected '^' at position 2"},
+		{"1 * * 2", "unexpected '*' at position 4"},
+		{"1.2.3 + a", "invalid number \"1.2.3\" at position 0"},
+		{"a + 1e", "invalid number \"1e\" at position 4"},
+	}
+	for _, c := range cases {
+		_, err := ParseExpression(c.src)
+		if err == nil {
+			t.Errorf("%q: expected an error", c.src)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.msg) {
+			t.Errorf("%q: got error %q, expected it to contain %q", c.src, err, c.msg)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package btrdb
+
+import (
+	"context"
+	"math/bits"
+	"sort"
+
+	"github.com/BTrDB/btrdb/v5/bte"
+)
+
+//The number of windows in the first level of a tree descent
+const descentRootWindows = 1024
+
+//The number of pointwidths descended at each level of a tree descent
+const descentStep = 4
+
+//Gap is an interval of a stream containing no points
+type Gap struct {
+	//The time of the last point before the gap, or the start of the query range
+	Start int64
+	//The time of the first point after the gap, or the end of the query range
+	End int64
+}
+
+//Duration returns the length of the gap in nanoseconds
+func (g Gap) Duration() int64 {
+	return g.End - g.Start
+}
+
+//alignDown clears the bottom pw bits of t
+func alignDown(t int64, pw uint8) int64 {
+	return t &^ ((1 << pw) - 1)
+}
+
+//FindGaps returns all the intervals in [start, end) at least minGap nanoseconds
+//long that contain no points, in time order. Rather than reading raw values, it
+//descends the tree using AlignedWindows, only refining windows that contain
+//fewer points than expected, so it touches a small fraction of the data on a
+//regularly sampled stream. The expected number of points in a window is
+//estimated from the densest window at the top level of the descent. The edges of
+//each gap are found exactly with Nearest. All queries are pinned to the same
+//version. minGap must be at least two nanoseconds.
+func (s *Stream) FindGaps(ctx context.Context, start int64, end int64, minGap uint64, version uint64) ([]Gap, error) {
+	if end <= start || minGap < 2 {
+		return nil, ErrorWrongArgs
+	}
+	//A gap of at least minGap always fully contains an aligned window of
+	//width minGap/2, so that is the finest level needed
+	leafPw := uint8(bits.Len64(minGap/2) - 1)
+	pw := countPointWidth(start, end, descentRootWindows)
+	if pw < leafPw {
+		pw = leafPw
+	}
+	windows, version, err := s.alignedWindowList(ctx, start, end, pw, version)
+	if err != nil {
+		return nil, err
+	}
+	var maxCount uint64
+	for _, w := range windows {
+		if w.Count > maxCount {
+			maxCount = w.Count
+		}
+	}
+	rate := float64(maxCount) / float64(int64(1)<<pw)
+
+	//empty holds the start of empty windows at every level, with their width
+	type window struct {
+		start int64
+		pw    uint8
+	}
+	empty := []window{}
+	classify := func(from int64, to int64, windows []StatPoint, pw uint8) []int64 {
+		counts := make(map[int64]uint64, len(windows))
+		for _, w := range windows {
+			counts[w.Time] = w.Count
+		}
+		candidates := []int64{}
+		width := int64(1) << pw
+		if to > end {
+			//Windows past the end of the range are empty only because they
+			//were not queried
+			to = end
+		}
+		for t := alignDown(from, pw); t < to; t += width {
+			c := counts[t]
+			if c == 0 {
+				empty = append(empty, window{t, pw})
+				continue
+			}
+			//If there were a gap of minGap, at least half of it would be in one
+			//of the windows it touches, so that window would have at most this many
+			//points if the stream were regularly sampled at the estimated rate
+			if pw > leafPw && float64(c) <= rate*float64(width-int64(minGap/2))+1 {
+				candidates = append(candidates, t)
+			}
+		}
+		return candidates
+	}
+	candidates := classify(start, end, windows, pw)
+	if err := s.descend(ctx, candidates, pw, leafPw, version, classify); err != nil {
+		return nil, err
+	}
+
+	//Merge touching empty windows into runs
+	sort.Slice(empty, func(i, j int) bool { return empty[i].start < empty[j].start })
+	rv := []Gap{}
+	for i := 0; i < len(empty); {
+		rs := empty[i].start
+		re := rs + int64(1)<<empty[i].pw
+		j := i + 1
+		for j < len(empty) && empty[j].start == re {
+			re += int64(1) << empty[j].pw
+			j++
+		}
+		i = j
+		g, err := s.gapAround(ctx, rs, re, start, end, version)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(g.Duration()) >= minGap {
+			rv = append(rv, g)
+		}
+	}
+	return rv, nil
+}
+
+//alignedWindowList returns the nonempty windows of the given pointwidth in
+//[start, end) and the version used. Unlike AlignedWindows, the partial window
+//at the end of the range is included, so it may extend past end.
+func (s *Stream) alignedWindowList(ctx context.Context, start int64, end int64, pw uint8, version uint64) ([]StatPoint, uint64, error) {
+	rv := []StatPoint{}
+	windows, verc, errc := s.AlignedWindows(ctx, start, alignUp(end, pw), pw, version)
+	for w := range windows {
+		rv = append(rv, w)
+	}
+	if err := <-errc; err != nil {
+		return nil, 0, err
+	}
+	if ver, ok := <-verc; ok {
+		version = ver
+	}
+	return rv, version, nil
+}
+
+//descend refines the candidate windows of pointwidth pw, a level at a time,
+//until there are none left or leafPw is reached. Each contiguous run of
+//candidates is queried at once at the next level, and classify is given the
+//run and its windows and returns the windows that need refining further.
+func (s *Stream) descend(ctx context.Context, candidates []int64, pw uint8, leafPw uint8, version uint64,
+	classify func(from int64, to int64, windows []StatPoint, pw uint8) []int64) error {
+	for pw > leafPw && len(candidates) > 0 {
+		npw := leafPw
+		if pw-leafPw > descentStep {
+			npw = pw - descentStep
+		}
+		ncandidates := []int64{}
+		width := int64(1) << pw
+		for i := 0; i < len(candidates); {
+			j := i + 1
+			for j < len(candidates) && candidates[j] == candidates[j-1]+width {
+				j++
+			}
+			rs, re := candidates[i], candidates[j-1]+width
+			windows, _, err := s.alignedWindowList(ctx, rs, re, npw, version)
+			if err != nil {
+				return err
+			}
+			ncandidates = append(ncandidates, classify(rs, re, windows, npw)...)
+			i = j
+		}
+		candidates = ncandidates
+		pw = npw
+	}
+	return nil
+}
+
+//gapAround extends the empty interval [from, to) to the nearest points on
+//either side, limited to [start, end)
+func (s *Stream) gapAround(ctx context.Context, from int64, to int64, start int64, end int64, version uint64) (Gap, error) {
+	g := Gap{Start: start, End: end}
+	if from > start {
+		p, _, err := s.Nearest(ctx, from, version, true)
+		if err != nil && ToCodedError(err).Code != bte.NoSuchPoint {
+			return g, err
+		}
+		if err == nil && p.Time > start {
+			g.Start = p.Time
+		}
+	}
+	if to < end {
+		p, _, err := s.Nearest(ctx, to, version, false)
+		if err != nil && ToCodedError(err).Code != bte.NoSuchPoint {
+			return g, err
+		}
+		if err == nil && p.Time < end {
+			g.End = p.Time
+		}
+	}
+	return g, nil
+}
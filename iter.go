@@ -0,0 +1,530 @@
+package btrdb
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/BTrDB/btrdb/v5/bte"
+	pb "github.com/BTrDB/btrdb/v5/v5api"
+	"github.com/pborman/uuid"
+)
+
+//The iterators in this file are an alternative to the channel based API. They
+//pull results from the underlying GRPC stream as Next() is called, so they
+//need not be fully consumed. A typical use is:
+//  it := stream.RawValuesIter(ctx, start, end, btrdb.LatestVersion)
+//  defer it.Close()
+//  for it.Next() {
+//    do stuff with it.Value()
+//  }
+//  if err := it.Err(); err != nil {
+//    handle error
+//  }
+
+//iterState is the common state shared by all the iterators
+type iterState struct {
+	b      *BTrDB
+	ep     *Endpoint
+	cancel context.CancelFunc
+	done   bool
+	closed bool
+	err    error
+	ver    uint64
+	gotVer bool
+}
+
+func failedIterState(err error) iterState {
+	return iterState{cancel: func() {}, done: true, err: err}
+}
+
+//check processes the result of a Recv call on the underlying GRPC stream. It
+//returns false if the iteration has ended
+func (it *iterState) check(err error, stat *pb.Status, ver uint64) bool {
+	if err == io.EOF {
+		it.finish(nil)
+		return false
+	}
+	if err != nil {
+		it.finish(err)
+		return false
+	}
+	if stat != nil {
+		it.finish(&CodedError{stat})
+		return false
+	}
+	if !it.gotVer {
+		it.ver = ver
+		it.gotVer = true
+	}
+	return true
+}
+
+func (it *iterState) finish(err error) {
+	it.done = true
+	it.err = err
+	if err != nil && it.b != nil {
+		//if err is special invalidate ep
+		it.b.TestEpError(it.ep, err)
+	}
+	it.cancel()
+}
+
+//versionKnown returns true once the version used by the query has been received
+func (it *iterState) versionKnown() bool {
+	return it.gotVer
+}
+
+//Err returns the error that ended the iteration, if any. It should be checked
+//after Next() returns false
+func (it *iterState) Err() error {
+	return it.err
+}
+
+//Close cancels the underlying GRPC stream and frees all resources associated
+//with the iterator. It is safe to call Close more than once, and it must be
+//called even if the iterator was fully consumed. Next returns false after
+//Close, even if values had already been received.
+func (it *iterState) Close() {
+	it.done = true
+	it.closed = true
+	it.cancel()
+}
+
+//RawPointIterator is a pull-style iterator over raw values
+type RawPointIterator struct {
+	iterState
+	recv func() (*pb.RawValuesResponse, error)
+	buf  []*pb.RawPoint
+	cur  RawPoint
+}
+
+//Next advances the iterator, returning false when there are no more values or
+//an error occurred
+func (it *RawPointIterator) Next() bool {
+	if it.closed {
+		return false
+	}
+	for len(it.buf) == 0 {
+		if it.done {
+			return false
+		}
+		rawv, err := it.recv()
+		if !it.check(err, rawv.GetStat(), rawv.GetVersionMajor()) {
+			return false
+		}
+		it.buf = rawv.Values
+	}
+	x := it.buf[0]
+	it.buf = it.buf[1:]
+	it.cur = RawPoint{x.Time, x.Value}
+	return true
+}
+
+//Value returns the current point
+func (it *RawPointIterator) Value() RawPoint {
+	return it.cur
+}
+
+//Version returns the version of the stream used to satisfy the query. It is
+//only valid after the first call to Next() returns true
+func (it *RawPointIterator) Version() uint64 {
+	return it.ver
+}
+
+//StatPointIterator is a pull-style iterator over statistical windows
+type StatPointIterator struct {
+	iterState
+	recv func() ([]*pb.StatPoint, *pb.Status, uint64, error)
+	buf  []*pb.StatPoint
+	cur  StatPoint
+}
+
+//Next advances the iterator, returning false when there are no more values or
+//an error occurred
+func (it *StatPointIterator) Next() bool {
+	if it.closed {
+		return false
+	}
+	for len(it.buf) == 0 {
+		if it.done {
+			return false
+		}
+		vals, stat, ver, err := it.recv()
+		if !it.check(err, stat, ver) {
+			return false
+		}
+		it.buf = vals
+	}
+	x := it.buf[0]
+	it.buf = it.buf[1:]
+	it.cur = StatPoint{
+		Time:   x.Time,
+		Min:    x.Min,
+		Mean:   x.Mean,
+		Max:    x.Max,
+		Count:  x.Count,
+		StdDev: x.Stddev,
+	}
+	return true
+}
+
+//Value returns the current window
+func (it *StatPointIterator) Value() StatPoint {
+	return it.cur
+}
+
+//Version returns the version of the stream used to satisfy the query. It is
+//only valid after the first call to Next() returns true
+func (it *StatPointIterator) Version() uint64 {
+	return it.ver
+}
+
+//ChangedRangeIterator is a pull-style iterator over changed ranges
+type ChangedRangeIterator struct {
+	iterState
+	recv func() (*pb.ChangesResponse, error)
+	buf  []*pb.ChangedRange
+	cur  ChangedRange
+}
+
+//Next advances the iterator, returning false when there are no more values or
+//an error occurred
+func (it *ChangedRangeIterator) Next() bool {
+	if it.closed {
+		return false
+	}
+	for len(it.buf) == 0 {
+		if it.done {
+			return false
+		}
+		cr, err := it.recv()
+		if !it.check(err, cr.GetStat(), cr.GetVersionMajor()) {
+			return false
+		}
+		it.buf = cr.Ranges
+	}
+	x := it.buf[0]
+	it.buf = it.buf[1:]
+	it.cur = ChangedRange{Version: it.ver, Start: x.Start, End: x.End}
+	return true
+}
+
+//Value returns the current changed range
+func (it *ChangedRangeIterator) Value() ChangedRange {
+	return it.cur
+}
+
+//Version returns the version of the stream used to satisfy the query. It is
+//only valid after the first call to Next() returns true
+func (it *ChangedRangeIterator) Version() uint64 {
+	return it.ver
+}
+
+//StreamIterator is a pull-style iterator over the results of a stream lookup
+type StreamIterator struct {
+	iterState
+	recv    func() (*pb.LookupStreamsResponse, error)
+	buf     []*pb.StreamDescriptor
+	cur     *Stream
+	patchDB *BTrDB
+}
+
+//Next advances the iterator, returning false when there are no more streams or
+//an error occurred
+func (it *StreamIterator) Next() bool {
+	if it.closed {
+		return false
+	}
+	for len(it.buf) == 0 {
+		if it.done {
+			return false
+		}
+		lr, err := it.recv()
+		if !it.check(err, lr.GetStat(), 0) {
+			return false
+		}
+		it.buf = lr.Results
+	}
+	it.cur = streamFromLookupResult(it.buf[0], it.patchDB)
+	it.buf = it.buf[1:]
+	return true
+}
+
+//Value returns the current stream
+func (it *StreamIterator) Value() *Stream {
+	return it.cur
+}
+
+//SQLRowIterator is a pull-style iterator over the rows of a metadata SQL query
+type SQLRowIterator struct {
+	iterState
+	recv func() (*pb.SQLQueryResponse, error)
+	buf  [][]byte
+	raw  []byte
+	cur  map[string]interface{}
+}
+
+//Next advances the iterator, returning false when there are no more rows or
+//an error occurred
+func (it *SQLRowIterator) Next() bool {
+	if it.closed {
+		return false
+	}
+	for len(it.buf) == 0 {
+		if it.done {
+			return false
+		}
+		row, err := it.recv()
+		if !it.check(err, row.GetStat(), 0) {
+			return false
+		}
+		it.buf = row.SQLQueryRow
+	}
+	m := make(map[string]interface{})
+	err := json.Unmarshal(it.buf[0], &m)
+	if err != nil {
+		it.finish(&CodedError{&pb.Status{Code: bte.BadSQLValue, Msg: "could not unmarshal SQL row"}})
+		return false
+	}
+	it.raw = it.buf[0]
+	it.buf = it.buf[1:]
+	it.cur = m
+	return true
+}
+
+//Value returns the current row
+func (it *SQLRowIterator) Value() map[string]interface{} {
+	return it.cur
+}
+
+//RawValuesIter is a low level function, rather use Stream.RawValuesIter()
+func (b *Endpoint) RawValuesIter(ctx context.Context, uu uuid.UUID, start int64, end int64, version uint64) (*RawPointIterator, error) {
+	ictx, cancel := context.WithCancel(ctx)
+	rv, err := b.g.RawValues(ictx, &pb.RawValuesParams{
+		Uuid:         uu,
+		Start:        start,
+		End:          end,
+		VersionMajor: version,
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &RawPointIterator{iterState: iterState{ep: b, cancel: cancel}, recv: rv.Recv}, nil
+}
+
+//AlignedWindowsIter is a low level function, rather use Stream.AlignedWindowsIter()
+func (b *Endpoint) AlignedWindowsIter(ctx context.Context, uu uuid.UUID, start int64, end int64, pointwidth uint8, version uint64) (*StatPointIterator, error) {
+	ictx, cancel := context.WithCancel(ctx)
+	rv, err := b.g.AlignedWindows(ictx, &pb.AlignedWindowsParams{
+		Uuid:         uu,
+		Start:        start,
+		End:          end,
+		PointWidth:   uint32(pointwidth),
+		VersionMajor: version,
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	recv := func() ([]*pb.StatPoint, *pb.Status, uint64, error) {
+		rawv, err := rv.Recv()
+		return rawv.GetValues(), rawv.GetStat(), rawv.GetVersionMajor(), err
+	}
+	return &StatPointIterator{iterState: iterState{ep: b, cancel: cancel}, recv: recv}, nil
+}
+
+//WindowsIter is a low level function, rather use Stream.WindowsIter()
+func (b *Endpoint) WindowsIter(ctx context.Context, uu uuid.UUID, start int64, end int64, width uint64, depth uint8, version uint64) (*StatPointIterator, error) {
+	ictx, cancel := context.WithCancel(ctx)
+	rv, err := b.g.Windows(ictx, &pb.WindowsParams{
+		Uuid:         uu,
+		Start:        start,
+		End:          end,
+		Width:        width,
+		Depth:        uint32(depth),
+		VersionMajor: version,
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	recv := func() ([]*pb.StatPoint, *pb.Status, uint64, error) {
+		rawv, err := rv.Recv()
+		return rawv.GetValues(), rawv.GetStat(), rawv.GetVersionMajor(), err
+	}
+	return &StatPointIterator{iterState: iterState{ep: b, cancel: cancel}, recv: recv}, nil
+}
+
+//ChangesIter is a low level function, rather use Stream.ChangesIter()
+func (b *Endpoint) ChangesIter(ctx context.Context, uu uuid.UUID, fromVersion uint64, toVersion uint64, resolution uint8) (*ChangedRangeIterator, error) {
+	ictx, cancel := context.WithCancel(ctx)
+	rv, err := b.g.Changes(ictx, &pb.ChangesParams{
+		Uuid:       uu,
+		FromMajor:  fromVersion,
+		ToMajor:    toVersion,
+		Resolution: uint32(resolution),
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &ChangedRangeIterator{iterState: iterState{ep: b, cancel: cancel}, recv: rv.Recv}, nil
+}
+
+//LookupStreamsIter is a low level function, rather use BTrDB.LookupStreamsIter()
+func (b *Endpoint) LookupStreamsIter(ctx context.Context, collection string, isCollectionPrefix bool, tags map[string]*string, annotations map[string]*string, patchDB *BTrDB) (*StreamIterator, error) {
+	ictx, cancel := context.WithCancel(ctx)
+	rv, err := b.g.LookupStreams(ictx, lookupStreamsParams(collection, isCollectionPrefix, tags, annotations))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &StreamIterator{iterState: iterState{ep: b, cancel: cancel}, recv: rv.Recv, patchDB: patchDB}, nil
+}
+
+//SQLQueryIter is a low level function, rather use BTrDB.SQLQueryIter()
+func (b *Endpoint) SQLQueryIter(ctx context.Context, query string, params []string) (*SQLRowIterator, error) {
+	ictx, cancel := context.WithCancel(ctx)
+	rv, err := b.g.SQLQuery(ictx, &pb.SQLQueryParams{
+		Query:  query,
+		Params: params,
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &SQLRowIterator{iterState: iterState{ep: b, cancel: cancel}, recv: rv.Recv}, nil
+}
+
+//RawValuesIter reads raw values from BTrDB like RawValues, but returns an
+//iterator that need not be fully consumed. Close must be called on the
+//returned iterator.
+func (s *Stream) RawValuesIter(ctx context.Context, start int64, end int64, version uint64) *RawPointIterator {
+	var ep *Endpoint
+	var err error
+	for s.b.TestEpError(ep, err) {
+		ep, err = s.b.ReadEndpointFor(ctx, s.uuid)
+		if err != nil {
+			continue
+		}
+		var it *RawPointIterator
+		it, err = ep.RawValuesIter(ctx, s.uuid, start, end, version)
+		if err != nil {
+			continue
+		}
+		it.b = s.b
+		return it
+	}
+	return &RawPointIterator{iterState: failedIterState(err)}
+}
+
+//AlignedWindowsIter reads power-of-two aligned windows from BTrDB like
+//AlignedWindows, but returns an iterator that need not be fully consumed.
+//Close must be called on the returned iterator.
+func (s *Stream) AlignedWindowsIter(ctx context.Context, start int64, end int64, pointwidth uint8, version uint64) *StatPointIterator {
+	var ep *Endpoint
+	var err error
+	for s.b.TestEpError(ep, err) {
+		ep, err = s.b.ReadEndpointFor(ctx, s.uuid)
+		if err != nil {
+			continue
+		}
+		var it *StatPointIterator
+		it, err = ep.AlignedWindowsIter(ctx, s.uuid, start, end, pointwidth, version)
+		if err != nil {
+			continue
+		}
+		it.b = s.b
+		return it
+	}
+	return &StatPointIterator{iterState: failedIterState(err)}
+}
+
+//WindowsIter returns arbitrary precision windows from BTrDB like Windows, but
+//returns an iterator that need not be fully consumed. Close must be called on
+//the returned iterator.
+func (s *Stream) WindowsIter(ctx context.Context, start int64, end int64, width uint64, depth uint8, version uint64) *StatPointIterator {
+	var ep *Endpoint
+	var err error
+	for s.b.TestEpError(ep, err) {
+		ep, err = s.b.ReadEndpointFor(ctx, s.uuid)
+		if err != nil {
+			continue
+		}
+		var it *StatPointIterator
+		it, err = ep.WindowsIter(ctx, s.uuid, start, end, width, depth, version)
+		if err != nil {
+			continue
+		}
+		it.b = s.b
+		return it
+	}
+	return &StatPointIterator{iterState: failedIterState(err)}
+}
+
+//ChangesIter returns the time intervals that have been altered between the two
+//given versions like Changes, but returns an iterator that need not be fully
+//consumed. Close must be called on the returned iterator.
+func (s *Stream) ChangesIter(ctx context.Context, fromVersion uint64, toVersion uint64, resolution uint8) *ChangedRangeIterator {
+	var ep *Endpoint
+	var err error
+	for s.b.TestEpError(ep, err) {
+		ep, err = s.b.ReadEndpointFor(ctx, s.uuid)
+		if err != nil {
+			continue
+		}
+		var it *ChangedRangeIterator
+		it, err = ep.ChangesIter(ctx, s.uuid, fromVersion, toVersion, resolution)
+		if err != nil {
+			continue
+		}
+		it.b = s.b
+		return it
+	}
+	return &ChangedRangeIterator{iterState: failedIterState(err)}
+}
+
+//LookupStreamsIter finds streams like LookupStreams, but returns an iterator
+//that need not be fully consumed. Close must be called on the returned iterator.
+func (b *BTrDB) LookupStreamsIter(ctx context.Context, collection string, isCollectionPrefix bool, tags map[string]*string, annotations map[string]*string) *StreamIterator {
+	var ep *Endpoint
+	var err error
+	for b.TestEpError(ep, err) {
+		ep, err = b.GetAnyEndpoint(ctx)
+		if err != nil {
+			continue
+		}
+		var it *StreamIterator
+		it, err = ep.LookupStreamsIter(ctx, collection, isCollectionPrefix, tags, annotations, b)
+		if err != nil {
+			continue
+		}
+		it.b = b
+		return it
+	}
+	return &StreamIterator{iterState: failedIterState(err)}
+}
+
+//SQLQueryIter executes a metadata SQL query like StreamingSQLQuery, but returns
+//an iterator that need not be fully consumed. Close must be called on the
+//returned iterator.
+func (b *BTrDB) SQLQueryIter(ctx context.Context, query string, params ...string) *SQLRowIterator {
+	var ep *Endpoint
+	var err error
+	for b.TestEpError(ep, err) {
+		ep, err = b.GetAnyEndpoint(ctx)
+		if err != nil {
+			continue
+		}
+		var it *SQLRowIterator
+		it, err = ep.SQLQueryIter(ctx, query, params)
+		if err != nil {
+			continue
+		}
+		it.b = b
+		return it
+	}
+	return &SQLRowIterator{iterState: failedIterState(err)}
+}
@@ -0,0 +1,141 @@
+package btrdb
+
+import (
+	"context"
+	"math/bits"
+)
+
+//The number of count pass windows per sub-range. More windows make the
+//sub-ranges more even, at the cost of a slightly larger count pass
+const countWindowsPerRange = 64
+
+//RawValuesParallel reads raw values like RawValues, but for large queries it
+//can be significantly faster. A cheap AlignedWindows count pass is used to split
+//[start, end) into sub-ranges containing roughly equal numbers of points, and up
+//to parallelism of those sub-ranges are fetched concurrently. All sub-ranges are
+//read at the version of the count pass, and the values are delivered in
+//timestamp order. At most parallelism sub-ranges are buffered in memory at once.
+//The returned RawPoint channel must be fully consumed.
+func (s *Stream) RawValuesParallel(ctx context.Context, start int64, end int64, version uint64, parallelism int) (chan RawPoint, chan uint64, chan error) {
+	rvc := make(chan RawPoint, 100)
+	rvv := make(chan uint64, 1)
+	rve := make(chan error, 1)
+	if parallelism < 1 || end <= start {
+		close(rvc)
+		close(rvv)
+		rve <- ErrorWrongArgs
+		close(rve)
+		return rvc, rvv, rve
+	}
+	go func() {
+		err := s.rawValuesParallel(ctx, start, end, version, parallelism, rvc, rvv)
+		close(rvc)
+		close(rvv)
+		if err != nil {
+			rve <- err
+		}
+		close(rve)
+	}()
+	return rvc, rvv, rve
+}
+
+type rangeResult struct {
+	times  []int64
+	values []float64
+	err    error
+}
+
+func (s *Stream) rawValuesParallel(ctx context.Context, start int64, end int64, version uint64, parallelism int, rvc chan RawPoint, rvv chan uint64) error {
+	bounds, ver, err := s.splitByCount(ctx, start, end, version, parallelism)
+	if err != nil {
+		return err
+	}
+	rvv <- ver
+	if len(bounds) < 2 {
+		return nil
+	}
+	ictx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]chan rangeResult, len(bounds)-1)
+	for i := range results {
+		results[i] = make(chan rangeResult, 1)
+	}
+	//The semaphore is released only once a sub-range has been delivered, which
+	//bounds the number of buffered sub-ranges as well as the concurrency
+	sem := make(chan struct{}, parallelism)
+	go func() {
+		for i := range results {
+			select {
+			case sem <- struct{}{}:
+			case <-ictx.Done():
+				return
+			}
+			go func(i int) {
+				t, v, _, err := s.RawValuesTV(ictx, bounds[i], bounds[i+1], ver, nil, nil)
+				results[i] <- rangeResult{times: t, values: v, err: err}
+			}(i)
+		}
+	}()
+	for i := range results {
+		var r rangeResult
+		select {
+		case r = <-results[i]:
+		case <-ictx.Done():
+			return ictx.Err()
+		}
+		if r.err != nil {
+			return r.err
+		}
+		for j := range r.times {
+			rvc <- RawPoint{Time: r.times[j], Value: r.values[j]}
+		}
+		<-sem
+	}
+	return nil
+}
+
+//splitByCount returns the boundaries of up to parts sub-ranges of [start, end)
+//having roughly equal numbers of points, and the version of the stream that the
+//counts were obtained at. If the counts show no points, the whole range is
+//returned as a single sub-range.
+func (s *Stream) splitByCount(ctx context.Context, start int64, end int64, version uint64, parts int) ([]int64, uint64, error) {
+	pw := countPointWidth(start, end, parts*countWindowsPerRange)
+	windows, version, err := s.alignedWindowList(ctx, start, end, pw, version)
+	if err != nil {
+		return nil, 0, err
+	}
+	var total uint64
+	for _, w := range windows {
+		total += w.Count
+	}
+	if total == 0 {
+		return []int64{start, end}, version, nil
+	}
+	perPart := total / uint64(parts)
+	if perPart == 0 {
+		perPart = 1
+	}
+	bounds := []int64{start}
+	var acc uint64
+	for _, w := range windows {
+		acc += w.Count
+		cut := w.Time + (1 << pw)
+		if acc >= perPart && cut > bounds[len(bounds)-1] && cut < end && len(bounds) < parts {
+			bounds = append(bounds, cut)
+			acc = 0
+		}
+	}
+	bounds = append(bounds, end)
+	return bounds, version, nil
+}
+
+//countPointWidth returns the smallest pointwidth that divides [start, end) into
+//at most nwindows aligned windows
+func countPointWidth(start int64, end int64, nwindows int) uint8 {
+	target := uint64(end-start) / uint64(nwindows)
+	pw := uint8(bits.Len64(target))
+	if pw > 62 {
+		pw = 62
+	}
+	return pw
+}
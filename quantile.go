@@ -0,0 +1,230 @@
+package btrdb
+
+import (
+	"context"
+	"math"
+	"sort"
+)
+
+//The number of aligned windows the range is first summarized with in
+//Quantiles and Histogram
+const quantileTopWindows = 1024
+
+//Ambiguous windows with at most this many points are read as raw values
+//rather than as finer windows
+const quantileRawThreshold = 4096
+
+//Histogram stops refining once at most this fraction of the points are in
+//windows that span a bin edge
+const histogramMaxAmbiguous = 0.01
+
+//Quantile is an approximate quantile of the points in a range
+type Quantile struct {
+	//The quantile, between 0 and 1
+	Q float64
+	//The estimate of the value at the quantile
+	Value float64
+	//The true value is within Error of Value
+	Error float64
+}
+
+//Histogram is an approximate histogram of the points in a range
+type Histogram struct {
+	//The bin edges. Bin i is [Edges[i], Edges[i+1]), except that the last bin
+	//also includes its upper edge, which is the maximum value in the range.
+	Edges []float64
+	//The estimated number of points in each bin
+	Counts []float64
+	//The true number of points in each bin is within Errors of Counts
+	Errors []uint64
+}
+
+//Quantiles returns the values at the given quantiles of the points in
+//[start, end). The value at quantile q is the smallest value v such that at
+//least a fraction q of the points are at most v, so a q of 0 is the minimum and
+//1 is the maximum. The quantiles are bounded using the min, max and count of
+//window summaries, and only windows that may contain a quantile are refined,
+//using finer windows and finally raw values, until every result is within
+//accuracy of the true value. An accuracy of zero gives exact results. It returns
+//ErrorNoPoints if there are no points in the range. All queries are pinned to
+//the same version, which is returned.
+func (s *Stream) Quantiles(ctx context.Context, start int64, end int64, qs []float64, accuracy float64, version uint64) ([]Quantile, uint64, error) {
+	if end <= start || !(accuracy >= 0) {
+		return nil, 0, ErrorWrongArgs
+	}
+	for _, q := range qs {
+		if !(q >= 0 && q <= 1) {
+			return nil, 0, ErrorWrongArgs
+		}
+	}
+	parts, version, err := s.rangeParts(ctx, start, end, quantileTopWindows, version)
+	if err != nil {
+		return nil, 0, err
+	}
+	rv := make([]Quantile, len(qs))
+	for {
+		n := mergeParts(parts).Count
+		if n == 0 {
+			return nil, 0, ErrorNoPoints
+		}
+		byMin := make([]statPart, len(parts))
+		copy(byMin, parts)
+		sort.Slice(byMin, func(i, j int) bool { return byMin[i].Min < byMin[j].Min })
+		byMax := make([]statPart, len(parts))
+		copy(byMax, parts)
+		sort.Slice(byMax, func(i, j int) bool { return byMax[i].Max < byMax[j].Max })
+
+		//The intervals that still need refining
+		spans := [][2]float64{}
+		for i, q := range qs {
+			r := uint64(math.Ceil(q*float64(n))) - 1
+			if q == 0 {
+				r = 0
+			}
+			if r >= n {
+				r = n - 1
+			}
+			//At least r+1 points are at most the true value, so it is no lower
+			//than the r+1th smallest min and no higher than the r+1th smallest max
+			lo := nthPart(byMin, r).Min
+			hi := nthPart(byMax, r).Max
+			rv[i] = Quantile{Q: q, Value: lo + (hi-lo)/2, Error: (hi - lo) / 2}
+			if rv[i].Error > accuracy {
+				spans = append(spans, [2]float64{lo, hi})
+			}
+		}
+		if len(spans) == 0 {
+			return rv, version, nil
+		}
+		var refined bool
+		parts, refined, err = s.refineParts(ctx, parts, version, func(p statPart) bool {
+			for _, sp := range spans {
+				if p.Min <= sp[1] && p.Max >= sp[0] {
+					return true
+				}
+			}
+			return false
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		if !refined {
+			return rv, version, nil
+		}
+	}
+}
+
+//Histogram returns an approximate histogram of the points in [start, end) with
+//the given number of equal width bins between the minimum and maximum value.
+//Windows that lie within a single bin are counted exactly. Windows that span a
+//bin edge are refined, using finer windows and finally raw values, until they
+//hold at most 1% of the points, and the points in the remaining ones are
+//divided between the bins in proportion to how much of the window's value range
+//lies in each. It returns ErrorNoPoints if there are no points in the range.
+//All queries are pinned to the same version, which is returned.
+func (s *Stream) Histogram(ctx context.Context, start int64, end int64, bins int, version uint64) (*Histogram, uint64, error) {
+	if end <= start || bins <= 0 {
+		return nil, 0, ErrorWrongArgs
+	}
+	parts, version, err := s.rangeParts(ctx, start, end, quantileTopWindows, version)
+	if err != nil {
+		return nil, 0, err
+	}
+	all := mergeParts(parts)
+	if all.Count == 0 {
+		return nil, 0, ErrorNoPoints
+	}
+	rv := &Histogram{
+		Edges:  make([]float64, bins+1),
+		Counts: make([]float64, bins),
+		Errors: make([]uint64, bins),
+	}
+	width := (all.Max - all.Min) / float64(bins)
+	for i := range rv.Edges {
+		rv.Edges[i] = all.Min + float64(i)*width
+	}
+	rv.Edges[bins] = all.Max
+	binOf := func(v float64) int {
+		if width == 0 {
+			return 0
+		}
+		i := int((v - all.Min) / width)
+		if i >= bins {
+			i = bins - 1
+		}
+		return i
+	}
+	ambiguous := func(p statPart) bool {
+		return binOf(p.Min) != binOf(p.Max)
+	}
+	for {
+		var namb uint64
+		for _, p := range parts {
+			if ambiguous(p) {
+				namb += p.Count
+			}
+		}
+		if float64(namb) <= histogramMaxAmbiguous*float64(all.Count) {
+			break
+		}
+		var refined bool
+		parts, refined, err = s.refineParts(ctx, parts, version, ambiguous)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !refined {
+			break
+		}
+	}
+	for _, p := range parts {
+		lo, hi := binOf(p.Min), binOf(p.Max)
+		if lo == hi {
+			rv.Counts[lo] += float64(p.Count)
+			continue
+		}
+		for i := lo; i <= hi; i++ {
+			overlap := math.Min(rv.Edges[i+1], p.Max) - math.Max(rv.Edges[i], p.Min)
+			rv.Counts[i] += float64(p.Count) * overlap / (p.Max - p.Min)
+			rv.Errors[i] += p.Count
+		}
+	}
+	return rv, version, nil
+}
+
+//nthPart returns the part containing the nth point, counting from zero, of
+//parts in their current order
+func nthPart(parts []statPart, n uint64) statPart {
+	var cum uint64
+	for _, p := range parts {
+		cum += p.Count
+		if cum > n {
+			return p
+		}
+	}
+	return parts[len(parts)-1]
+}
+
+//refineParts replaces each part for which ambiguous returns true with finer
+//parts covering the same window, or with raw values if the window is small. It
+//reports whether any part was replaced.
+func (s *Stream) refineParts(ctx context.Context, parts []statPart, version uint64, ambiguous func(p statPart) bool) ([]statPart, bool, error) {
+	rv := make([]statPart, 0, len(parts))
+	refined := false
+	for _, p := range parts {
+		//Parts with a single value are already exact
+		if p.Min == p.Max || !ambiguous(p) {
+			rv = append(rv, p)
+			continue
+		}
+		next := uint8(0)
+		if p.Count > quantileRawThreshold && p.PointWidth > drillStep {
+			next = p.PointWidth - drillStep
+		}
+		err := s.rangeStatParts(ctx, p.Time, p.Time+(1<<p.PointWidth), next, version, &rv)
+		if err != nil {
+			return nil, false, err
+		}
+		refined = true
+	}
+	return rv, refined, nil
+}
@@ -0,0 +1,127 @@
+package btrdb
+
+import (
+	"context"
+	"math/bits"
+)
+
+//QueryMode is the kind of query that Stream.Query chose to satisfy a request
+type QueryMode int
+
+const (
+	//QueryRaw means the result contains raw values
+	QueryRaw = QueryMode(iota)
+	//QueryAlignedWindows means the result contains power of two aligned windows
+	QueryAlignedWindows
+	//QueryWindows means the result contains arbitrary width windows
+	QueryWindows
+)
+
+func (m QueryMode) String() string {
+	switch m {
+	case QueryRaw:
+		return "raw"
+	case QueryAlignedWindows:
+		return "aligned windows"
+	case QueryWindows:
+		return "windows"
+	}
+	return "unknown"
+}
+
+//If aligned windows would produce fewer windows than this, the misalignment of
+//the first and last window with the query range is too noticeable, so arbitrary
+//windows are used instead
+const minAlignedWindows = 64
+
+//The number of windows in the count pass used to estimate density
+const densityWindows = 64
+
+//QueryResult is the result of a Stream.Query. Only one of Raw and Windows is
+//populated, depending on Mode.
+type QueryResult struct {
+	Mode QueryMode
+	//The width of each window in nanoseconds, zero for QueryRaw
+	Width uint64
+	//The pointwidth of the windows for QueryAlignedWindows
+	PointWidth uint8
+	//The version of the stream used to satisfy the query
+	Version uint64
+	Raw     []RawPoint
+	Windows []StatPoint
+}
+
+//Query returns a representation of [start, end) with roughly targetPoints
+//points, for example for plotting. A coarse count pass is used to estimate the
+//density of the stream. If there are no more than targetPoints raw values in the
+//range, they are returned. Otherwise windows are returned, using AlignedWindows
+//with the largest pointwidth that gives at least targetPoints windows, unless
+//that would give so few windows that the edge alignment would be visible, in
+//which case Windows is used with a width of (end-start)/targetPoints. All
+//queries are pinned to the version of the count pass.
+func (s *Stream) Query(ctx context.Context, start int64, end int64, targetPoints int, version uint64) (*QueryResult, error) {
+	if end <= start || targetPoints < 1 {
+		return nil, ErrorWrongArgs
+	}
+	total, version, err := s.estimateCount(ctx, start, end, version)
+	if err != nil {
+		return nil, err
+	}
+	rv := &QueryResult{Version: version}
+	if total <= uint64(targetPoints) {
+		rv.Mode = QueryRaw
+		vals, _, errc := s.RawValues(ctx, start, end, version)
+		for v := range vals {
+			rv.Raw = append(rv.Raw, v)
+		}
+		if err := <-errc; err != nil {
+			return nil, err
+		}
+		return rv, nil
+	}
+	ideal := uint64(end-start) / uint64(targetPoints)
+	if ideal == 0 {
+		ideal = 1
+	}
+	pw := uint8(bits.Len64(ideal) - 1)
+	var vals chan StatPoint
+	var errc chan error
+	if uint64(end-start)>>pw >= minAlignedWindows {
+		rv.Mode = QueryAlignedWindows
+		rv.PointWidth = pw
+		rv.Width = 1 << pw
+		vals, _, errc = s.AlignedWindows(ctx, start, end, pw, version)
+	} else {
+		rv.Mode = QueryWindows
+		rv.Width = ideal
+		//Be accurate to about a thousandth of a window
+		var depth uint8
+		if pw > 10 {
+			depth = pw - 10
+		}
+		vals, _, errc = s.Windows(ctx, start, end, ideal, depth, version)
+	}
+	for v := range vals {
+		rv.Windows = append(rv.Windows, v)
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+//estimateCount estimates the number of points in [start, end) with a coarse
+//AlignedWindows query. As the windows are aligned, points slightly outside the
+//range may be counted. It returns the version used.
+func (s *Stream) estimateCount(ctx context.Context, start int64, end int64, version uint64) (uint64, uint64, error) {
+	pw := countPointWidth(start, end, densityWindows)
+	windows, version, err := s.alignedWindowList(ctx, start, end, pw, version)
+	if err != nil {
+		return 0, 0, err
+	}
+	var total uint64
+	for _, w := range windows {
+		total += w.Count
+	}
+	return total, version, nil
+}
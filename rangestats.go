@@ -0,0 +1,115 @@
+package btrdb
+
+import (
+	"context"
+)
+
+//Edges of a range narrower than 2^statsRawPointWidth are read as raw values
+//rather than as aligned windows
+const statsRawPointWidth = 16
+
+//The number of aligned windows in the interior of the range at the top level
+const statsTopWindows = 64
+
+//statPart is a summary of the points in the aligned window [Time, Time+2^PointWidth).
+//Raw points are represented as windows of pointwidth zero.
+type statPart struct {
+	StatPoint
+	PointWidth uint8
+}
+
+//alignUp rounds t up to a multiple of 2^pw
+func alignUp(t int64, pw uint8) int64 {
+	return alignDown(t+(1<<pw)-1, pw)
+}
+
+//Stats returns the exact count, min, max, mean and standard deviation of the
+//points in [start, end), which need not be aligned. The Time of the result is
+//start. The aligned interior of the range is summarized with a few coarse
+//AlignedWindows, the remaining edges with successively finer AlignedWindows, and
+//only the very edges with RawValues, so this is efficient for large ranges. The
+//parts are merged exactly with MergeStatPoints. If there are no points, the
+//result has a Count of zero. All queries are pinned to the same version, which
+//is returned.
+func (s *Stream) Stats(ctx context.Context, start int64, end int64, version uint64) (StatPoint, uint64, error) {
+	if end <= start {
+		return StatPoint{}, 0, ErrorWrongArgs
+	}
+	parts, version, err := s.rangeParts(ctx, start, end, statsTopWindows, version)
+	if err != nil {
+		return StatPoint{}, 0, err
+	}
+	rv := mergeParts(parts)
+	rv.Time = start
+	return rv, version, nil
+}
+
+//CountRange returns the exact number of points in [start, end). See Stats.
+func (s *Stream) CountRange(ctx context.Context, start int64, end int64, version uint64) (uint64, error) {
+	st, _, err := s.Stats(ctx, start, end, version)
+	return st.Count, err
+}
+
+//rangeParts resolves the version and returns summaries covering exactly
+//[start, end), starting with about nwindows aligned windows in the interior
+func (s *Stream) rangeParts(ctx context.Context, start int64, end int64, nwindows int, version uint64) ([]statPart, uint64, error) {
+	if version == LatestVersion {
+		ver, err := s.Version(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		version = ver
+	}
+	parts := []statPart{}
+	pw := countPointWidth(start, end, nwindows)
+	if err := s.rangeStatParts(ctx, start, end, pw, version, &parts); err != nil {
+		return nil, 0, err
+	}
+	return parts, version, nil
+}
+
+//rangeStatParts appends summaries covering exactly [start, end) to parts,
+//using aligned windows of the given pointwidth for the aligned interior and
+//recursing on the unaligned edges
+func (s *Stream) rangeStatParts(ctx context.Context, start int64, end int64, pw uint8, version uint64, parts *[]statPart) error {
+	if start >= end {
+		return nil
+	}
+	if pw < statsRawPointWidth {
+		vals, _, errc := s.RawValues(ctx, start, end, version)
+		for v := range vals {
+			*parts = append(*parts, statPart{StatPoint: StatPoint{Time: v.Time, Min: v.Value, Mean: v.Value, Max: v.Value, Count: 1}})
+		}
+		return <-errc
+	}
+	next := uint8(0)
+	if pw > drillStep {
+		next = pw - drillStep
+	}
+	is, ie := alignUp(start, pw), alignDown(end, pw)
+	if is >= ie {
+		//No aligned window fits, try finer windows
+		return s.rangeStatParts(ctx, start, end, next, version, parts)
+	}
+	windows, _, errc := s.AlignedWindows(ctx, is, ie, pw, version)
+	for w := range windows {
+		if w.Count > 0 {
+			*parts = append(*parts, statPart{StatPoint: w, PointWidth: pw})
+		}
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+	if err := s.rangeStatParts(ctx, start, is, next, version, parts); err != nil {
+		return err
+	}
+	return s.rangeStatParts(ctx, ie, end, next, version, parts)
+}
+
+func mergeParts(parts []statPart) StatPoint {
+	pts := make([]StatPoint, len(parts))
+	for i, p := range parts {
+		pts[i] = p.StatPoint
+	}
+	return MergeStatPoints(pts...)
+}
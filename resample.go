@@ -0,0 +1,211 @@
+package btrdb
+
+import (
+	"context"
+	"math"
+
+	"github.com/BTrDB/btrdb/v5/bte"
+)
+
+//ResampleMethod determines how Stream.Resample computes the value at each grid point
+type ResampleMethod int
+
+const (
+	//ResamplePrevious uses the value of the last point at or before the grid point
+	ResamplePrevious = ResampleMethod(iota)
+	//ResampleLinear linearly interpolates between the points either side of the grid point
+	ResampleLinear
+	//ResampleNearest uses the value of the point closest to the grid point
+	ResampleNearest
+	//ResampleWindowMean uses the mean of the points in [grid point, grid point + period)
+	ResampleWindowMean
+)
+
+//ResampleOptions are optional parameters to Stream.Resample
+type ResampleOptions struct {
+	//If nonzero, grid points further than this many nanoseconds from the data
+	//they would be computed from are treated as gaps. For ResamplePrevious this
+	//is the distance to the previous point, for ResampleNearest the distance to
+	//the nearest point and for ResampleLinear the distance between the two points
+	//being interpolated. It is unused for ResampleWindowMean, where empty windows
+	//are always gaps.
+	MaxGap int64
+	//If true, grid points that are gaps are omitted, otherwise they are returned
+	//with a value of NaN
+	OmitGaps bool
+}
+
+//Resample returns the values of the stream on a regular grid of times start,
+//start+period, start+2*period... up to end (exclusive), computed with the given
+//method. The points immediately before start and after end are obtained with
+//Nearest so that the values at the edges of the range are correct. opts may be
+//nil. All queries are pinned to the same version. The RawPoint channel must be
+//fully consumed.
+func (s *Stream) Resample(ctx context.Context, start int64, end int64, period uint64, method ResampleMethod, version uint64, opts *ResampleOptions) (chan RawPoint, chan uint64, chan error) {
+	rvc := make(chan RawPoint, 100)
+	rvv := make(chan uint64, 1)
+	rve := make(chan error, 1)
+	if end <= start || period == 0 || method < ResamplePrevious || method > ResampleWindowMean {
+		close(rvc)
+		close(rvv)
+		rve <- ErrorWrongArgs
+		close(rve)
+		return rvc, rvv, rve
+	}
+	if opts == nil {
+		opts = &ResampleOptions{}
+	}
+	go func() {
+		var err error
+		if method == ResampleWindowMean {
+			err = s.resampleMean(ctx, start, end, period, version, opts, rvc, rvv)
+		} else {
+			err = s.resamplePoints(ctx, start, end, period, method, version, opts, rvc, rvv)
+		}
+		close(rvc)
+		close(rvv)
+		if err != nil {
+			rve <- err
+		}
+		close(rve)
+	}()
+	return rvc, rvv, rve
+}
+
+func (s *Stream) resampleMean(ctx context.Context, start int64, end int64, period uint64, version uint64, opts *ResampleOptions, rvc chan RawPoint, rvv chan uint64) error {
+	windows, verc, errc := s.Windows(ctx, start, end, period, 0, version)
+	g := start
+	emitGaps := func(until int64) {
+		for ; g < until; g += int64(period) {
+			if !opts.OmitGaps {
+				rvc <- RawPoint{Time: g, Value: math.NaN()}
+			}
+		}
+	}
+	wroteVer := false
+	for w := range windows {
+		if !wroteVer {
+			rvv <- <-verc
+			wroteVer = true
+		}
+		emitGaps(w.Time)
+		if w.Count == 0 {
+			emitGaps(w.Time + int64(period))
+			continue
+		}
+		rvc <- RawPoint{Time: w.Time, Value: w.Mean}
+		g = w.Time + int64(period)
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+	if !wroteVer {
+		if ver, ok := <-verc; ok {
+			rvv <- ver
+		}
+	}
+	//Windows drops a trailing partial window, so the grid ends at the same place
+	emitGaps(start + int64(period)*((end-start)/int64(period)))
+	return nil
+}
+
+func (s *Stream) resamplePoints(ctx context.Context, start int64, end int64, period uint64, method ResampleMethod, version uint64, opts *ResampleOptions, rvc chan RawPoint, rvv chan uint64) error {
+	//p0 is the last point at or before the current grid point, p1 is the point after
+	var p0, p1 *RawPoint
+	before, ver, err := s.Nearest(ctx, start, version, true)
+	if err != nil && ToCodedError(err).Code != bte.NoSuchPoint {
+		return err
+	}
+	if err == nil {
+		p0 = &before
+		version = ver
+	}
+	vals, verc, errc := s.RawValues(ctx, start, end, version)
+	if ver, ok := <-verc; ok {
+		version = ver
+	}
+	rvv <- version
+	afterFetched := false
+	var afterErr error
+	next := func() *RawPoint {
+		if p, ok := <-vals; ok {
+			return &p
+		}
+		if afterFetched {
+			return nil
+		}
+		afterFetched = true
+		if err := <-errc; err != nil {
+			afterErr = err
+			return nil
+		}
+		after, _, err := s.Nearest(ctx, end, version, false)
+		if err != nil {
+			if ToCodedError(err).Code != bte.NoSuchPoint {
+				afterErr = err
+			}
+			return nil
+		}
+		return &after
+	}
+	p1 = next()
+	for g := start; g < end && afterErr == nil; g += int64(period) {
+		for p1 != nil && p1.Time <= g {
+			p0 = p1
+			p1 = next()
+		}
+		v, ok := interpolate(method, g, p0, p1, opts.MaxGap)
+		if ok {
+			rvc <- RawPoint{Time: g, Value: v}
+		} else if !opts.OmitGaps {
+			rvc <- RawPoint{Time: g, Value: math.NaN()}
+		}
+	}
+	//Consume the remainder of the values
+	for !afterFetched {
+		next()
+	}
+	return afterErr
+}
+
+//interpolate computes the value at time g given the last point at or before
+//g and the first point after g, either of which may be nil. It returns false if
+//g is in a gap.
+func interpolate(method ResampleMethod, g int64, p0 *RawPoint, p1 *RawPoint, maxGap int64) (float64, bool) {
+	within := func(d int64) bool {
+		return maxGap == 0 || d <= maxGap
+	}
+	switch method {
+	case ResamplePrevious:
+		if p0 == nil || !within(g-p0.Time) {
+			return 0, false
+		}
+		return p0.Value, true
+	case ResampleNearest:
+		best := p0
+		if p1 != nil && (best == nil || p1.Time-g < g-best.Time) {
+			best = p1
+		}
+		if best == nil {
+			return 0, false
+		}
+		d := g - best.Time
+		if d < 0 {
+			d = -d
+		}
+		if !within(d) {
+			return 0, false
+		}
+		return best.Value, true
+	case ResampleLinear:
+		if p0 != nil && p0.Time == g {
+			return p0.Value, true
+		}
+		if p0 == nil || p1 == nil || !within(p1.Time-p0.Time) {
+			return 0, false
+		}
+		frac := float64(g-p0.Time) / float64(p1.Time-p0.Time)
+		return p0.Value + frac*(p1.Value-p0.Value), true
+	}
+	return 0, false
+}
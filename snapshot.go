@@ -0,0 +1,151 @@
+package btrdb
+
+import (
+	"context"
+	"sync"
+)
+
+//The maximum number of concurrent version lookups in SnapshotStreams
+const snapshotParallelism = 16
+
+//Snapshot is a handle on a stream pinned to a single version. All reads through
+//a Snapshot use that version, so multiple queries see consistent data even if
+//the stream is being written to concurrently.
+type Snapshot struct {
+	s       *Stream
+	version uint64
+}
+
+//Snapshot resolves the current version of the stream and returns a handle whose
+//read methods all use that version.
+func (s *Stream) Snapshot(ctx context.Context) (*Snapshot, error) {
+	ver, err := s.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{s: s, version: ver}, nil
+}
+
+//SnapshotAt returns a handle whose read methods all use the given version. It
+//returns ErrorVersionCompacted if the version is no longer accessible.
+func (s *Stream) SnapshotAt(ctx context.Context, version uint64) (*Snapshot, error) {
+	if version == LatestVersion {
+		return s.Snapshot(ctx)
+	}
+	ss := &Snapshot{s: s, version: version}
+	if err := ss.Check(ctx); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+//SnapshotStreams pins the current version of each of the given streams
+//concurrently, returning the snapshots in the same order as the streams.
+func (b *BTrDB) SnapshotStreams(ctx context.Context, streams []*Stream) ([]*Snapshot, error) {
+	rv := make([]*Snapshot, len(streams))
+	errs := make([]error, len(streams))
+	sem := make(chan struct{}, snapshotParallelism)
+	wg := sync.WaitGroup{}
+	wg.Add(len(streams))
+	for i, s := range streams {
+		sem <- struct{}{}
+		go func(i int, s *Stream) {
+			rv[i], errs[i] = s.Snapshot(ctx)
+			<-sem
+			wg.Done()
+		}(i, s)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
+
+//Stream returns the stream the snapshot is of
+func (ss *Snapshot) Stream() *Stream {
+	return ss.s
+}
+
+//Version returns the pinned version
+func (ss *Snapshot) Version() uint64 {
+	return ss.version
+}
+
+//Check returns ErrorVersionCompacted if the pinned version has been compacted
+//and can no longer be read
+func (ss *Snapshot) Check(ctx context.Context) error {
+	cfg, _, err := ss.s.GetCompactionConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if cfg.CompactedVersion > ss.version {
+		return ErrorVersionCompacted
+	}
+	return nil
+}
+
+//checkErr replaces an error from a read with ErrorVersionCompacted if it was
+//caused by the pinned version having been compacted
+func (ss *Snapshot) checkErr(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if cerr := ss.Check(ctx); cerr == ErrorVersionCompacted {
+		return cerr
+	}
+	return err
+}
+
+func (ss *Snapshot) snoopErr(ctx context.Context, errc chan error) chan error {
+	rv := make(chan error, 1)
+	go func() {
+		if err := <-errc; err != nil {
+			rv <- ss.checkErr(ctx, err)
+		}
+		close(rv)
+	}()
+	return rv
+}
+
+//RawValues is Stream.RawValues at the pinned version
+func (ss *Snapshot) RawValues(ctx context.Context, start int64, end int64) (chan RawPoint, chan error) {
+	rvc, _, errc := ss.s.RawValues(ctx, start, end, ss.version)
+	return rvc, ss.snoopErr(ctx, errc)
+}
+
+//AlignedWindows is Stream.AlignedWindows at the pinned version
+func (ss *Snapshot) AlignedWindows(ctx context.Context, start int64, end int64, pointwidth uint8) (chan StatPoint, chan error) {
+	rvc, _, errc := ss.s.AlignedWindows(ctx, start, end, pointwidth, ss.version)
+	return rvc, ss.snoopErr(ctx, errc)
+}
+
+//Windows is Stream.Windows at the pinned version
+func (ss *Snapshot) Windows(ctx context.Context, start int64, end int64, width uint64, depth uint8) (chan StatPoint, chan error) {
+	rvc, _, errc := ss.s.Windows(ctx, start, end, width, depth, ss.version)
+	return rvc, ss.snoopErr(ctx, errc)
+}
+
+//Nearest is Stream.Nearest at the pinned version
+func (ss *Snapshot) Nearest(ctx context.Context, time int64, backward bool) (RawPoint, error) {
+	rv, _, err := ss.s.Nearest(ctx, time, ss.version, backward)
+	return rv, ss.checkErr(ctx, err)
+}
+
+//Earliest is Stream.Earliest at the pinned version
+func (ss *Snapshot) Earliest(ctx context.Context, after int64) (RawPoint, error) {
+	return ss.Nearest(ctx, after, false)
+}
+
+//Latest is Stream.Latest at the pinned version
+func (ss *Snapshot) Latest(ctx context.Context, before int64) (RawPoint, error) {
+	return ss.Nearest(ctx, before, true)
+}
+
+//Count is Stream.Count at the pinned version
+func (ss *Snapshot) Count(ctx context.Context) (uint64, error) {
+	n, err := ss.s.Count(ctx, ss.version)
+	return n, ss.checkErr(ctx, err)
+}
@@ -0,0 +1,229 @@
+package btrdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	pb "github.com/BTrDB/btrdb/v5/v5api"
+	"github.com/pborman/uuid"
+)
+
+//The columns of the stream metadata table that StreamQuery accepts
+var streamColumns = map[string]bool{
+	"uuid":             true,
+	"collection":       true,
+	"tags":             true,
+	"annotations":      true,
+	"property_version": true,
+}
+
+//StreamQuery builds a parameterized SQL query against the stream metadata
+//table, so that queries need not be built by concatenating strings. Values are
+//always passed as parameters, and column names are checked against the known
+//columns. Conditions are combined with AND. Use it like:
+//
+//  q, params, err := btrdb.SelectStreams("uuid", "tags").
+//    CollectionPrefix("sensors/").
+//    TagEquals("unit", "volts").
+//    OrderBy("collection", false).
+//    Limit(100).
+//    Build()
+//  rows, errc := db.StreamingSQLQuery(ctx, q, params...)
+type StreamQuery struct {
+	columns []string
+	conds   []string
+	order   []string
+	params  []string
+	limit   int
+	offset  int
+	err     error
+}
+
+//SelectStreams starts a query returning the given columns of the stream
+//metadata table, or all of them if none are given
+func SelectStreams(columns ...string) *StreamQuery {
+	q := &StreamQuery{}
+	for _, c := range columns {
+		q.checkColumn(c)
+	}
+	q.columns = columns
+	return q
+}
+
+func (q *StreamQuery) checkColumn(c string) {
+	if !streamColumns[c] && q.err == nil {
+		q.err = &CodedError{&pb.Status{Code: 421, Msg: fmt.Sprintf("unknown stream column %q", c)}}
+	}
+}
+
+//param adds a parameter and returns its placeholder
+func (q *StreamQuery) param(v string) string {
+	q.params = append(q.params, v)
+	return "$" + strconv.Itoa(len(q.params))
+}
+
+func (q *StreamQuery) paramList(vs []string) string {
+	ph := make([]string, len(vs))
+	for i, v := range vs {
+		ph[i] = q.param(v)
+	}
+	return "(" + strings.Join(ph, ", ") + ")"
+}
+
+//in adds the condition that the expression returned by expr is one of the
+//values. An empty list matches nothing, and expr is not called, so that it does
+//not add unused parameters.
+func (q *StreamQuery) in(expr func() string, values []string) *StreamQuery {
+	if len(values) == 0 {
+		q.conds = append(q.conds, "FALSE")
+		return q
+	}
+	q.conds = append(q.conds, expr()+" IN "+q.paramList(values))
+	return q
+}
+
+func column(name string) func() string {
+	return func() string { return name }
+}
+
+//Collection matches streams in exactly the given collection
+func (q *StreamQuery) Collection(collection string) *StreamQuery {
+	q.conds = append(q.conds, "collection = "+q.param(collection))
+	return q
+}
+
+//CollectionPrefix matches streams whose collection starts with the prefix.
+//Wildcard characters in the prefix match literally.
+func (q *StreamQuery) CollectionPrefix(prefix string) *StreamQuery {
+	esc := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+	q.conds = append(q.conds, "collection LIKE "+q.param(esc+"%"))
+	return q
+}
+
+//CollectionIn matches streams in any of the given collections
+func (q *StreamQuery) CollectionIn(collections ...string) *StreamQuery {
+	return q.in(column("collection"), collections)
+}
+
+//UUIDIn matches the streams with the given uuids
+func (q *StreamQuery) UUIDIn(ids ...uuid.UUID) *StreamQuery {
+	vs := make([]string, len(ids))
+	for i, id := range ids {
+		vs[i] = id.String()
+	}
+	return q.in(column("uuid"), vs)
+}
+
+//TagEquals matches streams with the tag set to the value
+func (q *StreamQuery) TagEquals(key string, value string) *StreamQuery {
+	q.conds = append(q.conds, "tags -> "+q.param(key)+" = "+q.param(value))
+	return q
+}
+
+//HasTag matches streams that have the tag
+func (q *StreamQuery) HasTag(key string) *StreamQuery {
+	q.conds = append(q.conds, "tags ? "+q.param(key))
+	return q
+}
+
+//TagIn matches streams with the tag set to any of the values
+func (q *StreamQuery) TagIn(key string, values ...string) *StreamQuery {
+	return q.in(func() string { return "tags -> " + q.param(key) }, values)
+}
+
+//AnnotationEquals matches streams with the annotation set to the value
+func (q *StreamQuery) AnnotationEquals(key string, value string) *StreamQuery {
+	q.conds = append(q.conds, "annotations -> "+q.param(key)+" = "+q.param(value))
+	return q
+}
+
+//HasAnnotation matches streams that have the annotation
+func (q *StreamQuery) HasAnnotation(key string) *StreamQuery {
+	q.conds = append(q.conds, "annotations ? "+q.param(key))
+	return q
+}
+
+//AnnotationIn matches streams with the annotation set to any of the values
+func (q *StreamQuery) AnnotationIn(key string, values ...string) *StreamQuery {
+	return q.in(func() string { return "annotations -> " + q.param(key) }, values)
+}
+
+//OrderBy orders the results by the column. It may be called more than once to
+//break ties.
+func (q *StreamQuery) OrderBy(column string, descending bool) *StreamQuery {
+	q.checkColumn(column)
+	q.order = append(q.order, column+direction(descending))
+	return q
+}
+
+//OrderByTag orders the results by the value of the tag
+func (q *StreamQuery) OrderByTag(key string, descending bool) *StreamQuery {
+	q.order = append(q.order, "tags -> "+q.param(key)+direction(descending))
+	return q
+}
+
+//OrderByAnnotation orders the results by the value of the annotation
+func (q *StreamQuery) OrderByAnnotation(key string, descending bool) *StreamQuery {
+	q.order = append(q.order, "annotations -> "+q.param(key)+direction(descending))
+	return q
+}
+
+func direction(descending bool) string {
+	if descending {
+		return " DESC"
+	}
+	return " ASC"
+}
+
+//Limit returns at most n rows. Zero means no limit.
+func (q *StreamQuery) Limit(n int) *StreamQuery {
+	if n < 0 && q.err == nil {
+		q.err = ErrorWrongArgs
+	}
+	q.limit = n
+	return q
+}
+
+//Offset skips the first n rows
+func (q *StreamQuery) Offset(n int) *StreamQuery {
+	if n < 0 && q.err == nil {
+		q.err = ErrorWrongArgs
+	}
+	q.offset = n
+	return q
+}
+
+//Build returns the query and its parameters, for SQLQuery or
+//StreamingSQLQuery. It returns an error if an unknown column or a negative
+//limit or offset was given.
+func (q *StreamQuery) Build() (string, []string, error) {
+	if q.err != nil {
+		return "", nil, q.err
+	}
+	sb := strings.Builder{}
+	sb.WriteString("SELECT ")
+	if len(q.columns) == 0 {
+		sb.WriteString("*")
+	} else {
+		sb.WriteString(strings.Join(q.columns, ", "))
+	}
+	sb.WriteString(" FROM streams")
+	if len(q.conds) > 0 {
+		sb.WriteString(" WHERE ")
+		sb.WriteString(strings.Join(q.conds, " AND "))
+	}
+	if len(q.order) > 0 {
+		sb.WriteString(" ORDER BY ")
+		sb.WriteString(strings.Join(q.order, ", "))
+	}
+	if q.limit > 0 {
+		sb.WriteString(" LIMIT " + strconv.Itoa(q.limit))
+	}
+	if q.offset > 0 {
+		sb.WriteString(" OFFSET " + strconv.Itoa(q.offset))
+	}
+	params := make([]string, len(q.params))
+	copy(params, q.params)
+	return sb.String(), params, nil
+}
@@ -0,0 +1,94 @@
+package btrdb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pborman/uuid"
+)
+
+func TestStreamQueryBuild(t *testing.T) {
+	id := uuid.Parse("1ab2c3d4-0000-4000-8000-000000000001")
+	cases := []struct {
+		name   string
+		q      *StreamQuery
+		sql    string
+		params []string
+	}{
+		{
+			name:   "all",
+			q:      SelectStreams(),
+			sql:    "SELECT * FROM streams",
+			params: []string{},
+		},
+		{
+			name:   "collection prefix",
+			q:      SelectStreams("uuid", "collection").CollectionPrefix("sensors/a_b%"),
+			sql:    "SELECT uuid, collection FROM streams WHERE collection LIKE $1",
+			params: []string{`sensors/a\_b\%%`},
+		},
+		{
+			name: "tags and annotations",
+			q: SelectStreams("uuid").
+				Collection("sensors/x").
+				TagEquals("unit", "volts").
+				HasTag("name").
+				AnnotationEquals("site", "north").
+				HasAnnotation("phase"),
+			sql: "SELECT uuid FROM streams WHERE collection = $1 AND tags -> $2 = $3 AND tags ? $4" +
+				" AND annotations -> $5 = $6 AND annotations ? $7",
+			params: []string{"sensors/x", "unit", "volts", "name", "site", "north", "phase"},
+		},
+		{
+			name: "in lists",
+			q: SelectStreams("uuid").
+				CollectionIn("a", "b").
+				TagIn("unit", "volts", "amps").
+				AnnotationIn("site").
+				UUIDIn(id),
+			sql: "SELECT uuid FROM streams WHERE collection IN ($1, $2) AND tags -> $3 IN ($4, $5)" +
+				" AND FALSE AND uuid IN ($6)",
+			params: []string{"a", "b", "unit", "volts", "amps", id.String()},
+		},
+		{
+			name: "order limit offset",
+			q: SelectStreams("uuid", "tags").
+				HasTag("name").
+				OrderBy("collection", false).
+				OrderByTag("name", true).
+				OrderByAnnotation("site", false).
+				Limit(10).
+				Offset(20),
+			sql: "SELECT uuid, tags FROM streams WHERE tags ? $1" +
+				" ORDER BY collection ASC, tags -> $2 DESC, annotations -> $3 ASC LIMIT 10 OFFSET 20",
+			params: []string{"name", "name", "site"},
+		},
+	}
+	for _, c := range cases {
+		sql, params, err := c.q.Build()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if sql != c.sql {
+			t.Errorf("%s: got SQL\n  %s\nexpected\n  %s", c.name, sql, c.sql)
+		}
+		if !reflect.DeepEqual(params, c.params) {
+			t.Errorf("%s: got params %q, expected %q", c.name, params, c.params)
+		}
+	}
+}
+
+func TestStreamQueryErrors(t *testing.T) {
+	cases := map[string]*StreamQuery{
+		"unknown column":   SelectStreams("uuid; DROP TABLE streams"),
+		"unknown ordering": SelectStreams().OrderBy("name", false),
+		"negative limit":   SelectStreams().Limit(-1),
+		"negative offset":  SelectStreams().Offset(-1),
+	}
+	for name, q := range cases {
+		if _, _, err := q.Build(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package btrdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BTrDB/btrdb/v5/bte"
+	pb "github.com/BTrDB/btrdb/v5/v5api"
+	"github.com/pborman/uuid"
+)
+
+//The struct tag used to name the column a field is scanned from
+const sqlTag = "btrdb"
+
+var (
+	uuidType = reflect.TypeOf(uuid.UUID{})
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+//The field index paths by column name, by struct type
+var sqlFieldCache sync.Map
+
+func sqlScanError(format string, args ...interface{}) error {
+	return &CodedError{&pb.Status{Code: bte.BadSQLValue, Msg: fmt.Sprintf(format, args...)}}
+}
+
+//Scan copies the columns of the current row into the struct pointed to by
+//dest, which may also be a *map[string]interface{}. Each column is stored in
+//the field whose btrdb tag names it, like:
+//  type row struct {
+//    ID         uuid.UUID         `btrdb:"uuid"`
+//    Collection string            `btrdb:"collection"`
+//    Tags       map[string]string `btrdb:"tags"`
+//  }
+//Fields without a tag are matched by their lowercased name, fields tagged with
+//"-" are ignored, and the fields of untagged embedded structs are matched as if
+//they were fields of the outer struct. It is an error for a column to have no
+//matching field.
+//
+//Strings are parsed into uuid.UUID fields, integral numbers or numeric strings
+//into integer fields, and RFC 3339 strings or nanoseconds since the epoch into
+//time.Time fields. NULL values may only be stored in pointer, map, slice or
+//interface fields, and numbers are stored in interface fields as json.Number.
+//Other values are converted as encoding/json would. An error describing the
+//column and field is returned if a value cannot be converted.
+func (it *SQLRowIterator) Scan(dest interface{}) error {
+	if it.cur == nil {
+		return sqlScanError("Scan called without a current row")
+	}
+	if m, ok := dest.(*map[string]interface{}); ok {
+		*m = it.cur
+		return nil
+	}
+	//The row is decoded again so that numbers are exact, rather than the
+	//float64s in Value
+	row := make(map[string]interface{})
+	dec := json.NewDecoder(bytes.NewReader(it.raw))
+	dec.UseNumber()
+	if err := dec.Decode(&row); err != nil {
+		return &CodedError{&pb.Status{Code: bte.BadSQLValue, Msg: "could not unmarshal SQL row"}}
+	}
+	return scanRow(row, dest)
+}
+
+//SQLQueryInto executes a metadata SQL query and appends each row, scanned as by
+//SQLRowIterator.Scan, to the slice pointed to by dest. The slice elements may
+//be structs or pointers to structs.
+func (b *BTrDB) SQLQueryInto(ctx context.Context, dest interface{}, query string, params ...string) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() || dv.Elem().Kind() != reflect.Slice {
+		return sqlScanError("SQLQueryInto requires a pointer to a slice, not %T", dest)
+	}
+	slice := dv.Elem()
+	et := slice.Type().Elem()
+	isPtr := et.Kind() == reflect.Ptr
+	if isPtr {
+		et = et.Elem()
+	}
+	if et.Kind() != reflect.Struct {
+		return sqlScanError("SQLQueryInto requires a slice of structs, not %s", slice.Type())
+	}
+	it := b.SQLQueryIter(ctx, query, params...)
+	defer it.Close()
+	for it.Next() {
+		ev := reflect.New(et)
+		if err := it.Scan(ev.Interface()); err != nil {
+			return err
+		}
+		if isPtr {
+			slice.Set(reflect.Append(slice, ev))
+		} else {
+			slice.Set(reflect.Append(slice, ev.Elem()))
+		}
+	}
+	return it.Err()
+}
+
+func scanRow(row map[string]interface{}, dest interface{}) error {
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() || dv.Elem().Kind() != reflect.Struct {
+		return sqlScanError("Scan requires a pointer to a struct, not %T", dest)
+	}
+	sv := dv.Elem()
+	fields := sqlFields(sv.Type())
+	for col, val := range row {
+		idx, ok := fields[col]
+		if !ok {
+			return sqlScanError("column %q has no matching field in %s", col, sv.Type())
+		}
+		f := sv.FieldByIndex(idx)
+		if err := convertSQLValue(f, val); err != nil {
+			return sqlScanError("cannot scan column %q into field %s.%s of type %s: %v",
+				col, sv.Type(), sv.Type().FieldByIndex(idx).Name, f.Type(), err)
+		}
+	}
+	return nil
+}
+
+//sqlFields returns the index path of the field for each column name
+func sqlFields(t reflect.Type) map[string][]int {
+	if rv, ok := sqlFieldCache.Load(t); ok {
+		return rv.(map[string][]int)
+	}
+	rv := make(map[string][]int)
+	addSQLFields(t, nil, rv)
+	sqlFieldCache.Store(t, rv)
+	return rv
+}
+
+func addSQLFields(t reflect.Type, prefix []int, rv map[string][]int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		idx := append(append([]int{}, prefix...), i)
+		name, tagged := f.Tag.Lookup(sqlTag)
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && !tagged && f.Type.Kind() == reflect.Struct {
+			addSQLFields(f.Type, idx, rv)
+			continue
+		}
+		if f.PkgPath != "" {
+			//Unexported
+			continue
+		}
+		if !tagged {
+			name = strings.ToLower(f.Name)
+		}
+		//Fields of the outer struct take precedence over embedded ones
+		if _, ok := rv[name]; !ok || len(idx) < len(rv[name]) {
+			rv[name] = idx
+		}
+	}
+}
+
+//convertSQLValue stores a value unmarshaled from JSON in f. Numbers should be
+//json.Number, so that large integers such as nanosecond times are exact.
+func convertSQLValue(f reflect.Value, val interface{}) error {
+	if val == nil {
+		switch f.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+			f.Set(reflect.Zero(f.Type()))
+			return nil
+		}
+		return fmt.Errorf("value is NULL")
+	}
+	if f.Kind() == reflect.Interface && reflect.TypeOf(val).AssignableTo(f.Type()) {
+		f.Set(reflect.ValueOf(val))
+		return nil
+	}
+	if f.Kind() == reflect.Ptr {
+		nv := reflect.New(f.Type().Elem())
+		if err := convertSQLValue(nv.Elem(), val); err != nil {
+			return err
+		}
+		f.Set(nv)
+		return nil
+	}
+	switch f.Type() {
+	case uuidType:
+		s, ok := val.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %T", val)
+		}
+		uu := uuid.Parse(s)
+		if uu == nil {
+			return fmt.Errorf("%q is not a UUID", s)
+		}
+		f.Set(reflect.ValueOf(uu))
+		return nil
+	case timeType:
+		switch v := val.(type) {
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return err
+			}
+			f.Set(reflect.ValueOf(t))
+			return nil
+		case float64, json.Number:
+			ns, err := sqlInt(v)
+			if err != nil {
+				return err
+			}
+			f.Set(reflect.ValueOf(time.Unix(0, ns)))
+			return nil
+		}
+		return fmt.Errorf("expected a string or number, got %T", val)
+	}
+	switch f.Kind() {
+	case reflect.String:
+		s, ok := val.(string)
+		if !ok {
+			return fmt.Errorf("expected a string, got %T", val)
+		}
+		f.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, ok := val.(bool)
+		if !ok {
+			return fmt.Errorf("expected a bool, got %T", val)
+		}
+		f.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := sqlInt(val)
+		if err != nil {
+			return err
+		}
+		if f.OverflowInt(i) {
+			return fmt.Errorf("%d does not fit", i)
+		}
+		f.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := sqlUint(val)
+		if err != nil {
+			return err
+		}
+		if f.OverflowUint(u) {
+			return fmt.Errorf("%d does not fit", u)
+		}
+		f.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		n, err := sqlNumber(val)
+		if err != nil {
+			return err
+		}
+		if f.OverflowFloat(n) {
+			return fmt.Errorf("%v does not fit", n)
+		}
+		f.SetFloat(n)
+		return nil
+	}
+	//Maps, slices and structs are converted via JSON
+	buf, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	nv := reflect.New(f.Type())
+	if err := json.Unmarshal(buf, nv.Interface()); err != nil {
+		return err
+	}
+	f.Set(nv.Elem())
+	return nil
+}
+
+//sqlNumber returns a JSON number, or a string containing one, as a float64
+func sqlNumber(val interface{}) (float64, error) {
+	switch v := val.(type) {
+	case float64:
+		return v, nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(n) {
+			return 0, fmt.Errorf("%q is not a number", v)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("expected a number, got %T", val)
+}
+
+//sqlInt returns a JSON number, or a string containing one, as an int64. Integers
+//are parsed exactly.
+func sqlInt(val interface{}) (int64, error) {
+	var str string
+	switch v := val.(type) {
+	case json.Number:
+		str = string(v)
+	case string:
+		str = v
+	}
+	if str != "" {
+		if i, err := strconv.ParseInt(str, 10, 64); err == nil {
+			return i, nil
+		}
+	}
+	n, err := sqlNumber(val)
+	if err != nil {
+		return 0, err
+	}
+	if n != math.Trunc(n) || n < math.MinInt64 || n >= math.MaxInt64 {
+		return 0, fmt.Errorf("%v is not an integer that fits in 64 bits", n)
+	}
+	return int64(n), nil
+}
+
+//sqlUint is like sqlInt, but for unsigned integers
+func sqlUint(val interface{}) (uint64, error) {
+	var str string
+	switch v := val.(type) {
+	case json.Number:
+		str = string(v)
+	case string:
+		str = v
+	}
+	if str != "" {
+		if u, err := strconv.ParseUint(str, 10, 64); err == nil {
+			return u, nil
+		}
+	}
+	n, err := sqlNumber(val)
+	if err != nil {
+		return 0, err
+	}
+	if n != math.Trunc(n) || n < 0 || n >= math.MaxUint64 {
+		return 0, fmt.Errorf("%v is not an unsigned integer that fits in 64 bits", n)
+	}
+	return uint64(n), nil
+}
@@ -0,0 +1,150 @@
+package btrdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+//decodeRow decodes a row the way SQLRowIterator.Scan does
+func decodeRow(t *testing.T, js string) map[string]interface{} {
+	row := make(map[string]interface{})
+	dec := json.NewDecoder(bytes.NewReader([]byte(js)))
+	dec.UseNumber()
+	if err := dec.Decode(&row); err != nil {
+		t.Fatalf("bad test row %s: %v", js, err)
+	}
+	return row
+}
+
+type scanBase struct {
+	Collection string
+}
+
+type scanRowDest struct {
+	scanBase
+	ID      uuid.UUID         `btrdb:"uuid"`
+	Tags    map[string]string `btrdb:"tags"`
+	Version uint64            `btrdb:"property_version"`
+	Name    *string           `btrdb:"name"`
+	Created time.Time         `btrdb:"created"`
+	Other   interface{}       `btrdb:"other"`
+	Ignored int               `btrdb:"-"`
+}
+
+func TestScanRow(t *testing.T) {
+	row := decodeRow(t, `{
+		"collection": "sensors/a",
+		"uuid": "1ab2c3d4-0000-4000-8000-000000000001",
+		"tags": {"unit": "volts"},
+		"property_version": 18446744073709551615,
+		"name": null,
+		"created": 1700000000123456789,
+		"other": 12
+	}`)
+	var d scanRowDest
+	if err := scanRow(row, &d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := scanRowDest{
+		scanBase: scanBase{Collection: "sensors/a"},
+		ID:       uuid.Parse("1ab2c3d4-0000-4000-8000-000000000001"),
+		Tags:     map[string]string{"unit": "volts"},
+		Version:  18446744073709551615,
+		Created:  time.Unix(0, 1700000000123456789),
+		Other:    json.Number("12"),
+	}
+	if !reflect.DeepEqual(d, expected) {
+		t.Fatalf("got %+v, expected %+v", d, expected)
+	}
+}
+
+func TestScanRowErrors(t *testing.T) {
+	cases := []struct {
+		row  string
+		dest interface{}
+		msg  string
+	}{
+		{`{"nope": 1}`, &scanRowDest{}, `column "nope" has no matching field`},
+		{`{"ignored": 1}`, &scanRowDest{}, `column "ignored" has no matching field`},
+		{`{"uuid": 1}`, &scanRowDest{}, `column "uuid" into field btrdb.scanRowDest.ID of type uuid.UUID: expected a string, got json.Number`},
+		{`{"uuid": "xyz"}`, &scanRowDest{}, `"xyz" is not a UUID`},
+		{`{"property_version": -1}`, &scanRowDest{}, `field btrdb.scanRowDest.Version of type uint64: -1 is not an unsigned integer`},
+		{`{"property_version": "v1"}`, &scanRowDest{}, `"v1" is not a number`},
+		{`{"collection": null}`, &scanRowDest{}, `field btrdb.scanRowDest.Collection of type string: value is NULL`},
+		{`{"tags": "unit"}`, &scanRowDest{}, `column "tags" into field btrdb.scanRowDest.Tags`},
+		{`{}`, scanRowDest{}, `requires a pointer to a struct`},
+	}
+	for _, c := range cases {
+		err := scanRow(decodeRow(t, c.row), c.dest)
+		if err == nil {
+			t.Errorf("%s: expected an error", c.row)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.msg) {
+			t.Errorf("%s: got error %q, expected it to contain %q", c.row, err, c.msg)
+		}
+	}
+}
+
+func TestConvertSQLValue(t *testing.T) {
+	s := "x"
+	cases := []struct {
+		name     string
+		val      interface{}
+		expected interface{}
+	}{
+		{"int64 exact", json.Number("9223372036854775807"), int64(9223372036854775807)},
+		{"int from string", "-42", int(-42)},
+		{"int from exponent", json.Number("1e3"), int32(1000)},
+		{"uint64 exact", json.Number("18446744073709551615"), uint64(18446744073709551615)},
+		{"float", json.Number("1.5"), float64(1.5)},
+		{"float from string", "2.25", float32(2.25)},
+		{"bool", true, true},
+		{"string", "x", "x"},
+		{"pointer", "x", &s},
+		{"nil pointer", nil, (*string)(nil)},
+		{"time from number", json.Number("1700000000123456789"), time.Unix(0, 1700000000123456789)},
+		{"time from string", "2020-01-02T03:04:05.5Z", time.Date(2020, 1, 2, 3, 4, 5, 5e8, time.UTC)},
+		{"slice", []interface{}{json.Number("1"), json.Number("2")}, []int{1, 2}},
+	}
+	for _, c := range cases {
+		f := reflect.New(reflect.TypeOf(c.expected)).Elem()
+		if err := convertSQLValue(f, c.val); err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(f.Interface(), c.expected) {
+			t.Errorf("%s: got %#v, expected %#v", c.name, f.Interface(), c.expected)
+		}
+	}
+}
+
+func TestConvertSQLValueErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		val  interface{}
+		dest interface{}
+	}{
+		{"fraction into int", json.Number("1.5"), int64(0)},
+		{"overflow int8", json.Number("300"), int8(0)},
+		{"overflow int64", json.Number("9223372036854775808"), int64(0)},
+		{"negative uint", json.Number("-1"), uint(0)},
+		{"number into string", json.Number("1"), ""},
+		{"string into bool", "true", false},
+		{"null into int", nil, 0},
+		{"bool into time", true, time.Time{}},
+		{"bad time", "yesterday", time.Time{}},
+	}
+	for _, c := range cases {
+		f := reflect.New(reflect.TypeOf(c.dest)).Elem()
+		if err := convertSQLValue(f, c.val); err == nil {
+			t.Errorf("%s: expected an error, got %#v", c.name, f.Interface())
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package btrdb
+
+import (
+	"math"
+)
+
+//Variance returns the (population) variance of the window, i.e StdDev squared
+func (sp StatPoint) Variance() float64 {
+	return sp.StdDev * sp.StdDev
+}
+
+//Sum returns the sum of all the values in the window
+func (sp StatPoint) Sum() float64 {
+	return sp.Mean * float64(sp.Count)
+}
+
+//MergeStatPoints combines the statistical summaries of several windows into the
+//summary of a single window covering all of them. The result is exact, i.e it is
+//the same as if the summary had been calculated over all the underlying points.
+//The time of the result is the earliest time of the given points. Points with a
+//count of zero contribute only to the time.
+func MergeStatPoints(pts ...StatPoint) StatPoint {
+	rv := StatPoint{}
+	if len(pts) == 0 {
+		return rv
+	}
+	rv.Time = pts[0].Time
+	var sum float64
+	for _, p := range pts {
+		if p.Time < rv.Time {
+			rv.Time = p.Time
+		}
+		if p.Count == 0 {
+			continue
+		}
+		if rv.Count == 0 || p.Min < rv.Min {
+			rv.Min = p.Min
+		}
+		if rv.Count == 0 || p.Max > rv.Max {
+			rv.Max = p.Max
+		}
+		rv.Count += p.Count
+		sum += p.Sum()
+	}
+	if rv.Count == 0 {
+		return rv
+	}
+	rv.Mean = sum / float64(rv.Count)
+	//Parallel algorithm: the sum of squared deviations from the overall mean is
+	//the sum over windows of the within-window sum of squares plus the
+	//between-window term n*(mean_i - mean)^2
+	var m2 float64
+	for _, p := range pts {
+		if p.Count == 0 {
+			continue
+		}
+		d := p.Mean - rv.Mean
+		m2 += p.Variance()*float64(p.Count) + d*d*float64(p.Count)
+	}
+	rv.StdDev = math.Sqrt(m2 / float64(rv.Count))
+	return rv
+}
+
+//Rollup combines the time ordered windows from the given channel into coarser
+//windows newWidth nanoseconds long, aligned to multiples of newWidth since the
+//epoch. The windows in the input channel must each fit within a single output
+//window (e.g pointwidth 30 windows into hourly windows would not work, but
+//pointwidth 30 into pointwidth 36 would), in which case the results are exactly
+//what Windows or AlignedWindows would have returned for the coarser width. Output
+//windows are only produced where there is at least one input window. The input
+//channel is fully consumed, and the returned channel must be fully consumed. If
+//newWidth is zero or does not fit in an int64, no windows are produced.
+func Rollup(in chan StatPoint, newWidth uint64) chan StatPoint {
+	return RollupFrom(in, 0, newWidth)
+}
+
+//RollupFrom is like Rollup, but the output windows are aligned to
+//origin + k*newWidth. To match the results of Windows, pass the start time
+//of the query as the origin.
+func RollupFrom(in chan StatPoint, origin int64, newWidth uint64) chan StatPoint {
+	rv := make(chan StatPoint, 100)
+	go func() {
+		width := int64(newWidth)
+		if width <= 0 {
+			for range in {
+			}
+			close(rv)
+			return
+		}
+		var group []StatPoint
+		var groupStart int64
+		for p := range in {
+			ws := windowStart(p.Time, origin, width)
+			if len(group) > 0 && ws != groupStart {
+				rv <- rollupGroup(group, groupStart)
+				group = group[:0]
+			}
+			groupStart = ws
+			group = append(group, p)
+		}
+		if len(group) > 0 {
+			rv <- rollupGroup(group, groupStart)
+		}
+		close(rv)
+	}()
+	return rv
+}
+
+func rollupGroup(group []StatPoint, start int64) StatPoint {
+	rv := MergeStatPoints(group...)
+	rv.Time = start
+	return rv
+}
+
+//windowStart returns the start of the window of the given width, aligned to
+//origin, that contains t
+func windowStart(t int64, origin int64, width int64) int64 {
+	off := (t - origin) % width
+	if off < 0 {
+		off += width
+	}
+	return t - off
+}
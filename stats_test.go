@@ -0,0 +1,133 @@
+package btrdb
+
+import (
+	"math"
+	"testing"
+)
+
+//summarize calculates the summary of the values directly
+func summarize(time int64, vals []float64) StatPoint {
+	rv := StatPoint{Time: time, Count: uint64(len(vals))}
+	if len(vals) == 0 {
+		return rv
+	}
+	rv.Min, rv.Max = vals[0], vals[0]
+	var sum float64
+	for _, v := range vals {
+		rv.Min = math.Min(rv.Min, v)
+		rv.Max = math.Max(rv.Max, v)
+		sum += v
+	}
+	rv.Mean = sum / float64(len(vals))
+	var ss float64
+	for _, v := range vals {
+		ss += (v - rv.Mean) * (v - rv.Mean)
+	}
+	rv.StdDev = math.Sqrt(ss / float64(len(vals)))
+	return rv
+}
+
+func closeTo(a float64, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
+
+func checkStatPoint(t *testing.T, name string, got StatPoint, expected StatPoint) {
+	t.Helper()
+	if got.Time != expected.Time || got.Count != expected.Count || got.Min != expected.Min || got.Max != expected.Max ||
+		!closeTo(got.Mean, expected.Mean) || !closeTo(got.StdDev, expected.StdDev) {
+		t.Errorf("%s: got %+v, expected %+v", name, got, expected)
+	}
+}
+
+func TestMergeStatPoints(t *testing.T) {
+	vals := []float64{}
+	for i := 0; i < 100; i++ {
+		vals = append(vals, 1000+math.Sin(float64(i))*float64(i%7))
+	}
+	//Split into windows of uneven sizes, with very different means, including
+	//an empty window
+	groups := [][]float64{vals[:1], vals[1:10], vals[10:10], vals[10:60], vals[60:]}
+	for i := range groups[3] {
+		groups[3][i] -= 500
+	}
+	pts := []StatPoint{}
+	for i, g := range groups {
+		pts = append(pts, summarize(int64(100+i), g))
+	}
+	checkStatPoint(t, "merged", MergeStatPoints(pts...), summarize(100, vals))
+	checkStatPoint(t, "single", MergeStatPoints(pts[3]), pts[3])
+	checkStatPoint(t, "reversed", MergeStatPoints(pts[4], pts[3], pts[2], pts[1], pts[0]), summarize(100, vals))
+
+	empty := MergeStatPoints(StatPoint{Time: 7}, StatPoint{Time: 5})
+	if empty.Count != 0 || empty.Time != 5 {
+		t.Errorf("merging empty windows gave %+v", empty)
+	}
+	if none := MergeStatPoints(); none != (StatPoint{}) {
+		t.Errorf("merging nothing gave %+v", none)
+	}
+}
+
+func TestRollup(t *testing.T) {
+	//Windows of width 10 starting at 0, with values i and i+1 in window i
+	in := []StatPoint{}
+	raw := map[int64][]float64{}
+	for i := int64(0); i < 10; i++ {
+		if i == 4 {
+			//A missing window
+			continue
+		}
+		vs := []float64{float64(i), float64(i + 1)}
+		in = append(in, summarize(i*10, vs))
+		raw[i*10] = vs
+	}
+	run := func(origin int64, width uint64) []StatPoint {
+		c := make(chan StatPoint, len(in))
+		for _, p := range in {
+			c <- p
+		}
+		close(c)
+		rv := []StatPoint{}
+		for p := range RollupFrom(c, origin, width) {
+			rv = append(rv, p)
+		}
+		return rv
+	}
+	expect := func(origin int64, width int64) []StatPoint {
+		rv := []StatPoint{}
+		for ws := origin; ws < 100; ws += width {
+			vs := []float64{}
+			for t := ws; t < ws+width; t += 10 {
+				vs = append(vs, raw[t]...)
+			}
+			if len(vs) > 0 {
+				rv = append(rv, summarize(ws, vs))
+			}
+		}
+		return rv
+	}
+	for _, c := range []struct {
+		origin int64
+		width  uint64
+		//The start of the first output window
+		first int64
+	}{{0, 30, 0}, {-10, 30, -10}, {0, 10, 0}, {20, 40, -20}, {0, 1000, 0}} {
+		got := run(c.origin, c.width)
+		exp := expect(c.first, int64(c.width))
+		if len(got) != len(exp) {
+			t.Errorf("origin %d width %d: got %d windows, expected %d: %+v", c.origin, c.width, len(got), len(exp), got)
+			continue
+		}
+		for i := range got {
+			checkStatPoint(t, "rollup", got[i], exp[i])
+		}
+	}
+
+	//A zero width produces nothing, and still consumes the input
+	c := make(chan StatPoint)
+	out := Rollup(c, 0)
+	c <- in[0]
+	close(c)
+	if _, ok := <-out; ok {
+		t.Errorf("expected no windows for a zero width")
+	}
+}
@@ -0,0 +1,207 @@
+package btrdb
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+//The default interval between version polls in Subscribe and WatchCollection
+const defaultPollInterval = time.Second
+
+//CheckpointStore persists the last version of each stream whose changes have
+//been handled, so that a restarted subscription can resume where it left off.
+//Implementations must be safe for concurrent use.
+type CheckpointStore interface {
+	//LoadCheckpoint returns the stored version for the stream, or ok = false if
+	//there is none
+	LoadCheckpoint(ctx context.Context, id uuid.UUID) (version uint64, ok bool, err error)
+	//SaveCheckpoint stores the version for the stream
+	SaveCheckpoint(ctx context.Context, id uuid.UUID, version uint64) error
+}
+
+//MemoryCheckpointStore is a CheckpointStore that keeps checkpoints in memory.
+//It is useful for tests, or as a cache in front of a persistent store.
+type MemoryCheckpointStore struct {
+	mu       sync.Mutex
+	versions map[[16]byte]uint64
+}
+
+//NewMemoryCheckpointStore returns an empty MemoryCheckpointStore
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{versions: make(map[[16]byte]uint64)}
+}
+
+//LoadCheckpoint implements CheckpointStore
+func (m *MemoryCheckpointStore) LoadCheckpoint(ctx context.Context, id uuid.UUID) (uint64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ver, ok := m.versions[checkpointKey(id)]
+	return ver, ok, nil
+}
+
+//SaveCheckpoint implements CheckpointStore
+func (m *MemoryCheckpointStore) SaveCheckpoint(ctx context.Context, id uuid.UUID, version uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.versions[checkpointKey(id)] = version
+	return nil
+}
+
+func checkpointKey(id uuid.UUID) [16]byte {
+	var k [16]byte
+	copy(k[:], id)
+	return k
+}
+
+//SubscribeOptions configures Stream.Subscribe
+type SubscribeOptions struct {
+	//How often to poll the version of the stream. The default is one second.
+	Interval time.Duration
+	//Changes made after this version are delivered. If it is zero, the version
+	//in Checkpoint is used, or the current version if there is none, so that
+	//only future changes are delivered.
+	FromVersion uint64
+	//The resolution passed to Changes
+	Resolution uint8
+	//If true, events include the raw points in the changed ranges
+	IncludePoints bool
+	//If not nil, the version of each event is saved here once the event has
+	//been handled, and loaded when the subscription starts
+	Checkpoint CheckpointStore
+}
+
+//ChangeEvent describes the changes made to a stream between two versions
+type ChangeEvent struct {
+	Stream      *Stream
+	FromVersion uint64
+	Version     uint64
+	//The changed time ranges, sorted by start time
+	Ranges []ChangedRange
+	//The raw points in the changed ranges at Version, in time order, if
+	//IncludePoints was set
+	Points []RawPoint
+}
+
+//Subscribe polls the version of the stream and delivers an event whenever it
+//moves, carrying the ranges reported by Changes and optionally the new raw
+//points in them. Events are delivered in version order. If a Checkpoint store
+//is given, the version of an event is saved once the consumer receives the
+//following event, meaning it has finished handling the earlier one, so a
+//restarted subscription delivers each change at least once. The channels are
+//closed when the context is canceled, or after an error is delivered.
+func (s *Stream) Subscribe(ctx context.Context, opts SubscribeOptions) (chan ChangeEvent, chan error) {
+	rvc := make(chan ChangeEvent)
+	rve := make(chan error, 1)
+	go func() {
+		err := s.subscribe(ctx, opts, rvc)
+		close(rvc)
+		if err != nil && ctx.Err() == nil {
+			rve <- err
+		}
+		close(rve)
+	}()
+	return rvc, rve
+}
+
+func (s *Stream) subscribe(ctx context.Context, opts SubscribeOptions, rvc chan ChangeEvent) error {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	last, err := s.startVersion(ctx, opts.FromVersion, opts.Checkpoint)
+	if err != nil {
+		return err
+	}
+	//The version of the last event sent, which is saved once the next is received
+	var pending uint64
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ver, err := s.Version(ctx)
+		if err != nil {
+			return err
+		}
+		if ver > last {
+			ev, err := s.changeEvent(ctx, last, ver, opts.Resolution, opts.IncludePoints)
+			if err != nil {
+				return err
+			}
+			if len(ev.Ranges) > 0 {
+				select {
+				case rvc <- *ev:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				if pending != 0 && opts.Checkpoint != nil {
+					if err := opts.Checkpoint.SaveCheckpoint(ctx, s.uuid, pending); err != nil {
+						return err
+					}
+				}
+				pending = ver
+			}
+			last = ver
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+//startVersion returns the version changes should be delivered after
+func (s *Stream) startVersion(ctx context.Context, from uint64, cp CheckpointStore) (uint64, error) {
+	if from != 0 {
+		return from, nil
+	}
+	if cp != nil {
+		ver, ok, err := cp.LoadCheckpoint(ctx, s.uuid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return ver, nil
+		}
+	}
+	return s.Version(ctx)
+}
+
+//changeEvent builds the event for the changes between the two versions
+func (s *Stream) changeEvent(ctx context.Context, from uint64, to uint64, resolution uint8, includePoints bool) (*ChangeEvent, error) {
+	ev := &ChangeEvent{Stream: s, FromVersion: from, Version: to}
+	crc, _, errc := s.Changes(ctx, from, to, resolution)
+	for cr := range crc {
+		ev.Ranges = append(ev.Ranges, cr)
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+	sort.Slice(ev.Ranges, func(i, j int) bool { return ev.Ranges[i].Start < ev.Ranges[j].Start })
+	if !includePoints {
+		return ev, nil
+	}
+	var done int64 = MinimumTime
+	for _, r := range ev.Ranges {
+		//Do not include points in overlapping ranges twice
+		start := r.Start
+		if start < done {
+			start = done
+		}
+		if start >= r.End {
+			continue
+		}
+		vals, _, errc := s.RawValues(ctx, start, r.End, to)
+		for v := range vals {
+			ev.Points = append(ev.Points, v)
+		}
+		if err := <-errc; err != nil {
+			return nil, err
+		}
+		done = r.End
+	}
+	return ev, nil
+}
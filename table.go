@@ -0,0 +1,104 @@
+package btrdb
+
+import (
+	"context"
+)
+
+//TableRow is a single row of a multi-stream window table
+type TableRow struct {
+	//The start of the window, in nanoseconds since the epoch UTC
+	Time int64
+	//Cells[i] is the window for the i'th stream passed to the query, or nil if
+	//that stream has no data in this window
+	Cells []*StatPoint
+}
+
+//WindowsTable performs a Windows query on each of the given streams concurrently
+//and joins the results by window start time. The parameters are as for
+//Stream.Windows. Each stream is read at the latest version at the time of the
+//query, and that version is pinned for the duration of the query. The versions
+//used for each stream are written to the version channel, in the same order as
+//the streams, before any rows. The TableRow channel must be fully consumed.
+func (b *BTrDB) WindowsTable(ctx context.Context, streams []*Stream, start int64, end int64, width uint64, depth uint8) (chan TableRow, chan []uint64, chan error) {
+	vals := make([]chan StatPoint, len(streams))
+	vers := make([]chan uint64, len(streams))
+	errs := make([]chan error, len(streams))
+	for i, s := range streams {
+		vals[i], vers[i], errs[i] = s.Windows(ctx, start, end, width, depth, LatestVersion)
+	}
+	return joinWindows(vals, vers, errs)
+}
+
+//AlignedWindowsTable performs an AlignedWindows query on each of the given streams
+//concurrently and joins the results by window start time. The parameters are as
+//for Stream.AlignedWindows, and the results are as for BTrDB.WindowsTable. The
+//TableRow channel must be fully consumed.
+func (b *BTrDB) AlignedWindowsTable(ctx context.Context, streams []*Stream, start int64, end int64, pointwidth uint8) (chan TableRow, chan []uint64, chan error) {
+	vals := make([]chan StatPoint, len(streams))
+	vers := make([]chan uint64, len(streams))
+	errs := make([]chan error, len(streams))
+	for i, s := range streams {
+		vals[i], vers[i], errs[i] = s.AlignedWindows(ctx, start, end, pointwidth, LatestVersion)
+	}
+	return joinWindows(vals, vers, errs)
+}
+
+//joinWindows merges time ordered window channels into rows. All the input
+//channels are fully consumed, even if one of them fails.
+func joinWindows(vals []chan StatPoint, vers []chan uint64, errs []chan error) (chan TableRow, chan []uint64, chan error) {
+	rvc := make(chan TableRow, 100)
+	rvv := make(chan []uint64, 1)
+	rve := make(chan error, 1)
+	go func() {
+		versions := make([]uint64, len(vers))
+		for i, vc := range vers {
+			//If the query failed, the channel is closed without a value
+			versions[i] = <-vc
+		}
+		rvv <- versions
+		close(rvv)
+		heads := make([]*StatPoint, len(vals))
+		advance := func(i int) {
+			heads[i] = nil
+			if p, ok := <-vals[i]; ok {
+				heads[i] = &p
+			}
+		}
+		for i := range vals {
+			advance(i)
+		}
+		for {
+			found := false
+			var t int64
+			for _, h := range heads {
+				if h != nil && (!found || h.Time < t) {
+					t = h.Time
+					found = true
+				}
+			}
+			if !found {
+				break
+			}
+			row := TableRow{Time: t, Cells: make([]*StatPoint, len(heads))}
+			for i, h := range heads {
+				if h != nil && h.Time == t {
+					row.Cells[i] = h
+					advance(i)
+				}
+			}
+			rvc <- row
+		}
+		close(rvc)
+		var err error
+		for _, ec := range errs {
+			if e := <-ec; e != nil && err == nil {
+				err = e
+			}
+		}
+		if err != nil {
+			rve <- err
+		}
+		close(rve)
+	}()
+	return rvc, rvv, rve
+}
@@ -0,0 +1,216 @@
+package btrdb
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"time"
+
+	"github.com/BTrDB/btrdb/v5/bte"
+)
+
+//The default interval between lookups of the streams in WatchCollection
+const defaultRefreshInterval = time.Minute
+
+//WatchOptions configures BTrDB.WatchCollection
+type WatchOptions struct {
+	//How often to poll the versions of the streams. The default is one second.
+	Interval time.Duration
+	//How often to look up the streams in the collection again, to find new
+	//ones. The default is one minute.
+	RefreshInterval time.Duration
+	//The tags and annotations passed to LookupStreams
+	Tags        map[string]*string
+	Annotations map[string]*string
+	//The resolution passed to Changes
+	Resolution uint8
+	//If true, events include the raw points in the changed ranges
+	IncludePoints bool
+	//If not nil, the version of each event is saved here once the event has
+	//been handled, and loaded when a stream is first seen
+	Checkpoint CheckpointStore
+}
+
+type watchedStream struct {
+	s    *Stream
+	last uint64
+	//True if last is not yet known, because the stream has no checkpoint
+	//and its current version has not been polled
+	unknown bool
+}
+
+//WatchCollection delivers change events for every stream in the collections
+//with the given prefix (and matching the tags and annotations in opts) on a
+//single channel. The streams are looked up periodically, so streams created
+//after the watch starts are picked up, and streams that are deleted are
+//dropped. The versions are polled concurrently, grouped by the endpoint that
+//serves each stream. Streams present when the watch starts resume from their
+//checkpoint, or from their current version if they have none. Streams found
+//later resume from their checkpoint, or have all their data delivered.
+//
+//Events are delivered in polling rounds. Each stream's events are in version
+//order, and the events within a round are ordered by the start of their first
+//changed range. Checkpoints are saved as in Stream.Subscribe. The channels are
+//closed when the context is canceled, or after an error is delivered.
+func (b *BTrDB) WatchCollection(ctx context.Context, collectionPrefix string, opts WatchOptions) (chan ChangeEvent, chan error) {
+	rvc := make(chan ChangeEvent)
+	rve := make(chan error, 1)
+	go func() {
+		err := b.watchCollection(ctx, collectionPrefix, opts, rvc)
+		close(rvc)
+		if err != nil && ctx.Err() == nil {
+			rve <- err
+		}
+		close(rve)
+	}()
+	return rvc, rve
+}
+
+func (b *BTrDB) watchCollection(ctx context.Context, prefix string, opts WatchOptions, rvc chan ChangeEvent) error {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	refresh := opts.RefreshInterval
+	if refresh <= 0 {
+		refresh = defaultRefreshInterval
+	}
+	watched := make(map[[16]byte]*watchedStream)
+	var lastLookup time.Time
+	//The event sent last, which is checkpointed once the next is received
+	var pending *ChangeEvent
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if lastLookup.IsZero() || time.Since(lastLookup) >= refresh {
+			initial := lastLookup.IsZero()
+			streams, err := b.LookupStreams(ctx, prefix, true, opts.Tags, opts.Annotations)
+			if err != nil {
+				return err
+			}
+			lastLookup = time.Now()
+			if err := updateWatched(ctx, watched, streams, initial, opts.Checkpoint); err != nil {
+				return err
+			}
+		}
+		events, err := b.pollWatched(ctx, watched, opts)
+		if err != nil {
+			return err
+		}
+		for _, ev := range events {
+			select {
+			case rvc <- *ev:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if pending != nil && opts.Checkpoint != nil {
+				if err := opts.Checkpoint.SaveCheckpoint(ctx, pending.Stream.uuid, pending.Version); err != nil {
+					return err
+				}
+			}
+			pending = ev
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+//updateWatched adds new streams to watched and removes the ones that were
+//not found
+func updateWatched(ctx context.Context, watched map[[16]byte]*watchedStream, streams []*Stream, initial bool, cp CheckpointStore) error {
+	found := make(map[[16]byte]bool)
+	for _, s := range streams {
+		k := checkpointKey(s.uuid)
+		found[k] = true
+		if _, ok := watched[k]; ok {
+			continue
+		}
+		w := &watchedStream{s: s, unknown: initial}
+		if cp != nil {
+			ver, ok, err := cp.LoadCheckpoint(ctx, s.uuid)
+			if err != nil {
+				return err
+			}
+			if ok {
+				w.last, w.unknown = ver, false
+			}
+		}
+		watched[k] = w
+	}
+	for k := range watched {
+		if !found[k] {
+			delete(watched, k)
+		}
+	}
+	return nil
+}
+
+//pollWatched polls the versions of the watched streams and returns the events
+//for the ones that have changed, in delivery order
+func (b *BTrDB) pollWatched(ctx context.Context, watched map[[16]byte]*watchedStream, opts WatchOptions) ([]*ChangeEvent, error) {
+	list := make([]*watchedStream, 0, len(watched))
+	streams := make([]*Stream, 0, len(watched))
+	for _, w := range watched {
+		list = append(list, w)
+		streams = append(streams, w.s)
+	}
+	events := make([]*ChangeEvent, len(list))
+	errs := make([]error, len(list))
+	b.forEachByEndpoint(ctx, streams, func(ep *Endpoint, i int) {
+		w := list[i]
+		var ver uint64
+		var err error
+		if ep != nil {
+			_, _, _, _, ver, err = ep.StreamInfo(ctx, w.s.uuid, true, false)
+		}
+		if ep == nil || b.TestEpError(ep, err) {
+			//Fall back to the per stream retry logic
+			ver, err = w.s.Version(ctx)
+		}
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		if w.unknown {
+			w.last, w.unknown = ver, false
+			return
+		}
+		if ver <= w.last {
+			return
+		}
+		ev, err := w.s.changeEvent(ctx, w.last, ver, opts.Resolution, opts.IncludePoints)
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		w.last = ver
+		if len(ev.Ranges) > 0 {
+			events[i] = ev
+		}
+	})
+	rv := []*ChangeEvent{}
+	for i, err := range errs {
+		if err == nil {
+			if events[i] != nil {
+				rv = append(rv, events[i])
+			}
+			continue
+		}
+		if ToCodedError(err).Code == bte.NoSuchStream {
+			//The stream has been deleted since it was looked up
+			delete(watched, checkpointKey(list[i].s.uuid))
+			continue
+		}
+		return nil, err
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].Ranges[0].Start != rv[j].Ranges[0].Start {
+			return rv[i].Ranges[0].Start < rv[j].Ranges[0].Start
+		}
+		return bytes.Compare(rv[i].Stream.uuid, rv[j].Stream.uuid) < 0
+	})
+	return rv, nil
+}